@@ -0,0 +1,74 @@
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/customerio/homework/serve"
+	"github.com/hashicorp/go-memdb"
+)
+
+func (d Datastore) Batch(ops []serve.BatchOperation, atomic bool) ([]serve.BatchResult, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+
+	results := make([]serve.BatchResult, len(ops))
+	failed := false
+
+	for i, op := range ops {
+		customer, created, err := applyOperation(txn, op)
+		if err != nil && !isOperationError(err) {
+			// the txn may be half way through a write, give up on all of it
+			return nil, err
+		}
+
+		results[i] = serve.BatchResult{Customer: customer, Created: created, Err: err}
+		if err != nil {
+			failed = true
+			if atomic {
+				break
+			}
+		}
+	}
+
+	if failed && atomic {
+		// nothing happened after all
+		for i := range results {
+			results[i].Customer, results[i].Created = nil, false
+		}
+		return results, nil
+	}
+
+	txn.Commit()
+	return results, nil
+}
+
+// applyOperation - created is only set by creates that made a new customer
+func applyOperation(txn *memdb.Txn, op serve.BatchOperation) (customer *serve.Customer, created bool, err error) {
+	switch op.Op {
+	case serve.BatchCreate:
+		return createCustomer(txn, op.ID, op.Attributes, op.Upsert)
+	case serve.BatchUpdate:
+		customer, err = writeCustomer(txn, op.ID, op.Version, mergeAttributes(op.Attributes, op.Removed))
+	case serve.BatchReplace:
+		customer, err = writeCustomer(txn, op.ID, op.Version, replaceAttributes(op.Attributes))
+	case serve.BatchDelete:
+		err = deleteCustomer(txn, op.ID, op.Version)
+	default:
+		err = &operationError{fmt.Errorf("unknown operation %q", op.Op)}
+	}
+	return customer, false, err
+}
+
+// operationError - an operation that can't be applied as given
+type operationError struct {
+	error
+}
+
+// isOperationError - failures detected before anything was written by the operation,
+// which leave the txn usable for the rest of the batch
+func isOperationError(err error) bool {
+	if _, ok := err.(*operationError); ok {
+		return true
+	}
+	return serve.IsNotFound(err) || serve.IsConflict(err) || serve.IsPreconditionFailed(err)
+}
@@ -0,0 +1,83 @@
+package datastore_test
+
+import (
+	"testing"
+
+	"github.com/customerio/homework/serve"
+)
+
+func TestBatch(t *testing.T) {
+	ops := []serve.BatchOperation{
+		{Op: serve.BatchCreate, Attributes: map[string]string{"email": "new@example.com"}},
+		{Op: serve.BatchUpdate, ID: 1, Attributes: map[string]string{"tier": "S"}},
+		// rejected: taken email and id, unknown customer, stale version
+		{Op: serve.BatchCreate, Attributes: map[string]string{"email": "customer2@example.com"}},
+		{Op: serve.BatchCreate, ID: 3, Attributes: map[string]string{"email": "other@example.com"}},
+		{Op: serve.BatchDelete, ID: 1000},
+		{Op: serve.BatchReplace, ID: 2, Attributes: map[string]string{"email": "customer2@example.com"}, Version: 7},
+		{Op: serve.BatchDelete, ID: 4},
+	}
+
+	t.Run("atomic", func(t *testing.T) {
+		ds := newTestDatastore(t)
+
+		results, err := ds.Batch(ops, true)
+		if err != nil {
+			t.Fatalf("error running batch: %v", err)
+		}
+		if !serve.IsConflict(results[2].Err) {
+			t.Errorf("first failure not reported: %#v", results[2])
+		}
+		for i, r := range results {
+			if r.Customer != nil {
+				t.Errorf("operation %d of a failed atomic batch returned a customer", i)
+			}
+		}
+
+		if c, _ := ds.Get(1); c.Attributes["tier"] != "A" || c.Version != 1 {
+			t.Errorf("update of a failed atomic batch applied: %#v", c)
+		}
+		if _, err := ds.Get(4); err != nil {
+			t.Errorf("delete of a failed atomic batch applied: %v", err)
+		}
+		if total, _ := ds.TotalCustomers(); total != testCustomers {
+			t.Errorf("%d customers after a failed atomic batch, want %d", total, testCustomers)
+		}
+
+		// no id was burned by the rolled back create
+		if c, _, err := ds.Create(0, map[string]string{"email": "next@example.com"}, false); err != nil || c.ID != testCustomers+1 {
+			t.Errorf("next id after a failed atomic batch: %v, %v", c, err)
+		}
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		ds := newTestDatastore(t)
+
+		results, err := ds.Batch(ops, false)
+		if err != nil {
+			t.Fatalf("error running batch: %v", err)
+		}
+
+		wantErrs := []func(error) bool{nil, nil, serve.IsConflict, serve.IsConflict, serve.IsNotFound, serve.IsPreconditionFailed, nil}
+		for i, want := range wantErrs {
+			if want == nil && results[i].Err != nil || want != nil && !want(results[i].Err) {
+				t.Errorf("operation %d: %v", i, results[i].Err)
+			}
+		}
+		if results[0].Customer == nil || results[0].Customer.ID != testCustomers+1 {
+			t.Fatalf("created customer: %#v", results[0].Customer)
+		}
+
+		if c, _ := ds.Get(1); c.Attributes["tier"] != "S" {
+			t.Errorf("update of a partial batch not applied: %#v", c)
+		}
+		if _, err := ds.Get(4); !serve.IsNotFound(err) {
+			t.Errorf("delete of a partial batch not applied: %v", err)
+		}
+
+		// the rejected creates didn't burn ids
+		if c, _, err := ds.Create(0, map[string]string{"email": "next@example.com"}, false); err != nil || c.ID != testCustomers+2 {
+			t.Errorf("next id after a partial batch: %v, %v", c, err)
+		}
+	})
+}
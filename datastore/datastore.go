@@ -1,29 +1,46 @@
 package datastore
 
 import (
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/customerio/homework/serve"
-	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+	"github.com/customerio/homework/utils"
 	"github.com/hashicorp/go-memdb"
 	"github.com/labstack/gommon/log"
 )
 
 const (
-	customerTableName = "customer"
+	customerTableName   = "customer"
+	segmentTableName    = "segment"
+	membershipTableName = "membership"
+	termTableName       = "term"
+	sequenceTableName   = "sequence"
+	eventTableName      = "event"
+	pendingTableName    = "pending"
+	historyTableName    = "history"
+	deletedTableName    = "deleted"
 )
 
 // Datastore - in memory concurrent map based data store
 type Datastore struct {
-	customers *memdb.MemDB
+	db *memdb.MemDB
+	// history - whether the records applied to customers are kept, see GetAsOf
+	history bool
+	// config - of the summaries of the events tracked, the same as the summarizer's
+	config summarize.Config
 }
 
-// CreateDatastore - creates data store by summarizedEvents and summarizedAttributes
-func CreateDatastore(summarizedAttributes map[string]stream.Record, summarizedEvents map[string]map[string]int) (Datastore, error) {
+var _ serve.Datastore = Datastore{}
 
-	// Create the DB schema
-	schema := &memdb.DBSchema{
+// schema - customers, saved segments, the customer <-> segment memberships
+// the inverted index of attribute values used by search, id sequences, the ids of
+// the events counted so far, the events of customers not identified yet, their histories
+// and the last versions of deleted customers
+func schema() *memdb.DBSchema {
+	return &memdb.DBSchema{
 		Tables: map[string]*memdb.TableSchema{
 			customerTableName: &memdb.TableSchema{
 				Name: customerTableName,
@@ -33,63 +50,230 @@ func CreateDatastore(summarizedAttributes map[string]stream.Record, summarizedEv
 						Unique:  true,
 						Indexer: &memdb.IntFieldIndex{Field: "ID"},
 					},
+					"email": &memdb.IndexSchema{
+						Name:         "email",
+						Unique:       true,
+						AllowMissing: true,
+						Indexer:      &emailIndex{},
+					},
+				},
+			},
+			segmentTableName: &memdb.TableSchema{
+				Name: segmentTableName,
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.IntFieldIndex{Field: "ID"},
+					},
+				},
+			},
+			membershipTableName: &memdb.TableSchema{
+				Name: membershipTableName,
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:   "id",
+						Unique: true,
+						Indexer: &memdb.CompoundIndex{
+							Indexes: []memdb.Indexer{
+								&memdb.IntFieldIndex{Field: "SegmentID"},
+								&memdb.IntFieldIndex{Field: "CustomerID"},
+							},
+						},
+					},
+					"segment": &memdb.IndexSchema{
+						Name:    "segment",
+						Indexer: &memdb.IntFieldIndex{Field: "SegmentID"},
+					},
+					"customer": &memdb.IndexSchema{
+						Name:    "customer",
+						Indexer: &memdb.IntFieldIndex{Field: "CustomerID"},
+					},
+				},
+			},
+			termTableName: &memdb.TableSchema{
+				Name: termTableName,
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:   "id",
+						Unique: true,
+						Indexer: &memdb.CompoundIndex{
+							Indexes: []memdb.Indexer{
+								&memdb.StringFieldIndex{Field: "Term"},
+								&memdb.IntFieldIndex{Field: "CustomerID"},
+							},
+						},
+					},
+					"term": &memdb.IndexSchema{
+						Name:    "term",
+						Indexer: &memdb.StringFieldIndex{Field: "Term"},
+					},
+					"customer": &memdb.IndexSchema{
+						Name:    "customer",
+						Indexer: &memdb.IntFieldIndex{Field: "CustomerID"},
+					},
+				},
+			},
+			sequenceTableName: &memdb.TableSchema{
+				Name: sequenceTableName,
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "Name"},
+					},
+				},
+			},
+			eventTableName: &memdb.TableSchema{
+				Name: eventTableName,
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.StringFieldIndex{Field: "ID"},
+					},
+				},
+			},
+			pendingTableName: &memdb.TableSchema{
+				Name: pendingTableName,
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.IntFieldIndex{Field: "CustomerID"},
+					},
+				},
+			},
+			historyTableName: &memdb.TableSchema{
+				Name: historyTableName,
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.IntFieldIndex{Field: "CustomerID"},
+					},
+				},
+			},
+			deletedTableName: &memdb.TableSchema{
+				Name: deletedTableName,
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": &memdb.IndexSchema{
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.IntFieldIndex{Field: "CustomerID"},
+					},
 				},
 			},
 		},
 	}
+}
+
+// CreateDatastore - creates data store from the summarized users, the ids of the events
+// already counted are kept so that tracking them again is a no-op. When the summarizer kept
+// its history, the datastore keeps it too, along with the records tracked from then on.
+// A customer with the email of a more recently updated one is logged and left out.
+func CreateDatastore(summary *summarize.Summarizer) (Datastore, error) {
 
 	// Create a new database
-	csm, err := memdb.NewMemDB(schema)
+	db, err := memdb.NewMemDB(schema())
 	if err != nil {
 		return Datastore{}, err
 	}
 
-	txn := csm.Txn(true)
-	for k, rec := range summarizedAttributes {
+	txn := db.Txn(true)
+	for _, k := range loadOrder(summary.Users) {
+		user := summary.Users[k]
 		var customerId int
 		var err error
 
+		if !user.Identified() {
+			// events of users without attributes are kept until they are identified
+			if customerId, err = strconv.Atoi(k); err != nil {
+				// can never be identified
+				continue
+			}
+			if err := txn.Insert(pendingTableName, newPendingEvents(customerId, user)); err != nil {
+				return Datastore{}, err
+			}
+			continue
+		}
+
 		if customerId, err = strconv.Atoi(k); err != nil {
 			return Datastore{}, err
 		}
 
-		events := summarizedEvents[k]
-		if events == nil {
-			// expected by the verify-script
-			events = make(map[string]int)
+		if err := checkEmail(txn, customerId, user.Attributes["email"]); err != nil {
+			if !serve.IsConflict(err) {
+				return Datastore{}, err
+			}
+			// the messages can't be fixed from here, so the rest are served
+			log.Warnf("skipping customer %d: %v, taken by a more recently updated customer", customerId, err)
+			continue
 		}
 
-		cs := &serve.Customer{
-			ID:          customerId,
-			Attributes:  rec.Data,
-			Events:      events,
-			LastUpdated: int(rec.Timestamp),
+		if err := putCustomer(txn, customerFromSummary(customerId, user, 1)); err != nil {
+			log.Error(err)
+			return Datastore{}, err
 		}
+		if err := advanceSequence(txn, customerSequence, customerId); err != nil {
+			return Datastore{}, err
+		}
+	}
 
-		if err := txn.Insert(customerTableName, cs); err != nil {
-			log.Error(err)
+	for id := range summary.EventIDs {
+		if err := txn.Insert(eventTableName, &seenEvent{ID: id}); err != nil {
 			return Datastore{}, err
 		}
 	}
+
+	for k, records := range summary.History {
+		customerId, err := strconv.Atoi(k)
+		if err != nil {
+			continue
+		}
+		// capped so the first append copies rather than writing to the summarizer's array
+		history := &customerHistory{CustomerID: customerId, Records: records[:len(records):len(records)]}
+		if err := txn.Insert(historyTableName, history); err != nil {
+			return Datastore{}, err
+		}
+	}
+
 	// commit all writes
 	txn.Commit()
 
 	return Datastore{
-		customers: csm,
+		db:      db,
+		history: summary.History != nil,
+		config:  summary.Config,
 	}, nil
 }
 
+// loadOrder - the user ids of the summaries, the most recently updated first so that when customers
+// have the same email the most recent one keeps it (last write wins), ties going to the smaller id
+func loadOrder(users map[string]*summarize.Summary) []string {
+	ids := make([]string, 0, len(users))
+	for k := range users {
+		ids = append(ids, k)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := users[ids[i]], users[ids[j]]
+		if a.Timestamp != b.Timestamp {
+			return a.Timestamp > b.Timestamp
+		}
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) < len(ids[j])
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
 func (d Datastore) Get(id int) (*serve.Customer, error) {
 
-	txn := d.customers.Txn(false)
+	txn := d.db.Txn(false)
 	defer txn.Abort()
 
-	if raw, err := txn.First(customerTableName, "id", id); err == nil && raw != nil {
-		customer := raw.(*serve.Customer)
-		return customer, nil
-	}
-
-	return nil, serve.ErrNotFound
+	return getCustomer(txn, id)
 }
 
 func (d Datastore) List(page, count int) ([]*serve.Customer, error) {
@@ -100,7 +284,7 @@ func (d Datastore) List(page, count int) ([]*serve.Customer, error) {
 
 	cs := make([]*serve.Customer, 0, count)
 
-	txn := d.customers.Txn(false)
+	txn := d.db.Txn(false)
 	defer txn.Abort()
 
 	// List all the customers
@@ -123,17 +307,92 @@ func (d Datastore) List(page, count int) ([]*serve.Customer, error) {
 	return cs, nil
 }
 
-func (d Datastore) Create(id int, attributes map[string]string) (*serve.Customer, error) {
+// Each - walks the customers of a read txn, so writes made meanwhile are not seen and nothing is copied
+func (d Datastore) Each(filter serve.CustomerFilter, fn func(*serve.Customer) error) error {
+	txn := d.db.Txn(false)
+	defer txn.Abort()
 
-	customer := &serve.Customer{
-		ID:          id,
-		Attributes:  attributes,
-		Events:      nil,
-		LastUpdated: int(time.Now().Unix()),
+	var segment *serve.Segment
+	if filter.Segment != 0 {
+		var err error
+		if segment, err = getSegment(txn, filter.Segment); err != nil {
+			return err
+		}
 	}
+	tokens := tokenize(filter.Query)
 
-	txn := d.customers.Txn(true)
-	if err := txn.Insert(customerTableName, customer); err != nil {
+	it, err := txn.Get(customerTableName, "id")
+	if err != nil {
+		return err
+	}
+
+	for obj := it.Next(); obj != nil; obj = it.Next() {
+		customer := obj.(*serve.Customer)
+
+		// segments with time windows are evaluated as of now, see SegmentCustomers
+		if segment != nil && segment.Windowed() {
+			if !segment.Matches(customer) {
+				continue
+			}
+		} else if segment != nil {
+			member, err := txn.First(membershipTableName, "id", filter.Segment, customer.ID)
+			if err != nil {
+				return err
+			}
+			if member == nil {
+				continue
+			}
+		}
+		if len(tokens) > 0 && !matchesQuery(customer, tokens) {
+			continue
+		}
+
+		if err := fn(customer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create - fails with a serve.ConflictError if a customer with the id already exists,
+// unless upsert is set in which case its attributes are replaced and its events kept.
+// An id of 0 gets the next id of the customer sequence.
+func (d Datastore) Create(id int, attributes map[string]string, upsert bool) (*serve.Customer, bool, error) {
+
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+
+	customer, created, err := createCustomer(txn, id, attributes, upsert)
+	if err != nil {
+		return nil, false, err
+	}
+
+	txn.Commit()
+	return customer, created, nil
+}
+
+// Update - merges the attributes into the customer's, attributes listed in removed are deleted
+func (d Datastore) Update(id int, attributes map[string]string, removed []string, version int) (*serve.Customer, error) {
+	return d.write(id, version, mergeAttributes(attributes, removed))
+}
+
+// Patch - applies JSON Patch operations to the customer's attributes, all in the same txn
+func (d Datastore) Patch(id int, ops []serve.PatchOperation, version int) (*serve.Customer, error) {
+	return d.write(id, version, patchAttributes(ops))
+}
+
+// Replace - replaces all the attributes of the customer
+func (d Datastore) Replace(id int, attributes map[string]string, version int) (*serve.Customer, error) {
+	return d.write(id, version, replaceAttributes(attributes))
+}
+
+func (d Datastore) write(id, version int, fn attributesFunc) (*serve.Customer, error) {
+
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+
+	customer, err := writeCustomer(txn, id, version, fn)
+	if err != nil {
 		return nil, err
 	}
 
@@ -141,42 +400,224 @@ func (d Datastore) Create(id int, attributes map[string]string) (*serve.Customer
 	return customer, nil
 }
 
-func (d Datastore) Update(id int, attributes map[string]string) (*serve.Customer, error) {
+func (d Datastore) Delete(id, version int) error {
+
+	txn := d.db.Txn(true)
+	defer txn.Abort()
 
-	var customer *serve.Customer
+	if err := deleteCustomer(txn, id, version); err != nil {
+		return err
+	}
+
+	txn.Commit()
+	return nil
+}
+
+// attributesFunc - computes the new attributes of a customer from its current ones
+type attributesFunc func(customer *serve.Customer) (map[string]string, error)
+
+func mergeAttributes(attributes map[string]string, removed []string) attributesFunc {
+	return func(customer *serve.Customer) (map[string]string, error) {
+		merged := utils.MergeMaps(attributes, customer.Attributes, true)
+		for _, key := range removed {
+			delete(merged, key)
+		}
+		return merged, nil
+	}
+}
+
+func patchAttributes(ops []serve.PatchOperation) attributesFunc {
+	return func(customer *serve.Customer) (map[string]string, error) {
+		return serve.ApplyPatch(customer.Attributes, ops)
+	}
+}
+
+func replaceAttributes(attributes map[string]string) attributesFunc {
+	return func(customer *serve.Customer) (map[string]string, error) {
+		return utils.CopyMap(attributes), nil
+	}
+}
+
+func createCustomer(txn *memdb.Txn, id int, attributes map[string]string, upsert bool) (*serve.Customer, bool, error) {
 	var err error
+	if id == 0 {
+		if id, err = peekID(txn, customerSequence); err != nil {
+			return nil, false, err
+		}
+	}
+
+	existing, err := getCustomer(txn, id)
+	if err != nil && !serve.IsNotFound(err) {
+		return nil, false, err
+	}
+	if existing != nil && !upsert {
+		return nil, false, &serve.ConflictError{Field: "id", Value: strconv.Itoa(id)}
+	}
+
+	if err := checkEmail(txn, id, attributes["email"]); err != nil {
+		return nil, false, err
+	}
+	// written once nothing can fail the operation any more, see isOperationError
+	if err := advanceSequence(txn, customerSequence, id); err != nil {
+		return nil, false, err
+	}
+
+	version, err := nextVersion(txn, id, existing)
+	if err != nil {
+		return nil, false, err
+	}
+
+	customer := &serve.Customer{
+		ID:          id,
+		Attributes:  utils.CopyMap(attributes),
+		Events:      nil,
+		LastUpdated: int(time.Now().Unix()),
+		Version:     version,
+	}
+	if existing != nil {
+		clone := existing.Clone()
+		customer.Events, customer.Aggregates, customer.Averaged, customer.Histogram = clone.Events, clone.Aggregates, clone.Averaged, clone.Histogram
+	} else if pending, err := takePendingEvents(txn, id); err != nil {
+		return nil, false, err
+	} else if pending != nil {
+		customer.Events, customer.Aggregates, customer.Averaged, customer.Histogram = pending.Events, pending.Aggregates, pending.Averaged, pending.Histogram
+	}
 
-	if customer, err = d.Get(id); err != nil {
+	if err := putCustomer(txn, customer); err != nil {
+		return nil, false, err
+	}
+	return customer, existing == nil, nil
+}
+
+// writeCustomer - updates the attributes of an existing customer to the ones returned by fn,
+// a version other than 0 must match the stored one.
+// Stored customers are never modified in place (copy-on-write): readers may be holding
+// them outside of any txn, and memdb derives the index entries to remove from them.
+func writeCustomer(txn *memdb.Txn, id, version int, fn attributesFunc) (*serve.Customer, error) {
+	customer, err := getCustomer(txn, id)
+	if err != nil {
 		return nil, err
 	}
+	if version != 0 && customer.Version != version {
+		return nil, serve.ErrPreconditionFailed
+	}
 
-	// customer.Attributes = utils.MergeMaps(attributes, customer.Attributes, true)
-	// in order to handle removal of attributes
-	customer.Attributes = attributes
-	customer.LastUpdated = int(time.Now().Unix())
-	txn := d.customers.Txn(true)
-	if err := txn.Insert(customerTableName, customer); err != nil {
+	attributes, err := fn(customer)
+	if err != nil {
 		return nil, err
 	}
-	txn.Commit()
-	return customer, nil
+	if err := checkEmail(txn, id, attributes["email"]); err != nil {
+		return nil, err
+	}
+
+	updated := customer.Clone()
+	updated.Attributes = attributes
+	updated.Versions = unchangedVersions(customer, attributes)
+	updated.LastUpdated = int(time.Now().Unix())
+	updated.Version++
+
+	if err := putCustomer(txn, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
 }
 
-func (d Datastore) Delete(id int) error {
+// unchangedVersions - the versions of the attributes an edit keeps the value of, the edited ones
+// take the version of the edit, i.e. LastUpdated
+func unchangedVersions(customer *serve.Customer, attributes map[string]string) map[string]summarize.Version {
+	var versions map[string]summarize.Version
+	for name, v := range customer.Versions {
+		if value, ok := attributes[name]; ok && value == customer.Attributes[name] {
+			if versions == nil {
+				versions = make(map[string]summarize.Version, len(customer.Versions))
+			}
+			versions[name] = v
+		}
+	}
+	return versions
+}
 
-	var customer *serve.Customer
-	var err error
+// deletedCustomer - the last version of a deleted customer, a customer created with the same id
+// carries on from it so that the ETags of the deleted one never match
+type deletedCustomer struct {
+	CustomerID int
+	Version    int
+}
 
-	if customer, err = d.Get(id); err != nil {
+// nextVersion - the version of the customer written next: one past the existing customer's,
+// or of the last deleted customer with the id, if any
+func nextVersion(txn *memdb.Txn, id int, existing *serve.Customer) (int, error) {
+	if existing != nil {
+		return existing.Version + 1, nil
+	}
+
+	raw, err := txn.First(deletedTableName, "id", id)
+	if err != nil || raw == nil {
+		return 1, err
+	}
+	return raw.(*deletedCustomer).Version + 1, nil
+}
+
+func deleteCustomer(txn *memdb.Txn, id, version int) error {
+	customer, err := getCustomer(txn, id)
+	if err != nil {
 		return err
 	}
+	if version != 0 && customer.Version != version {
+		return serve.ErrPreconditionFailed
+	}
 
-	txn := d.customers.Txn(true)
 	if err := txn.Delete(customerTableName, customer); err != nil {
 		return err
 	}
-	txn.Commit()
-	return nil
+	if err := txn.Insert(deletedTableName, &deletedCustomer{CustomerID: customer.ID, Version: customer.Version}); err != nil {
+		return err
+	}
+	if _, err := txn.DeleteAll(membershipTableName, "customer", customer.ID); err != nil {
+		return err
+	}
+	// a customer created with the id later on starts from scratch
+	for _, table := range []string{historyTableName, pendingTableName} {
+		if _, err := txn.DeleteAll(table, "id", customer.ID); err != nil {
+			return err
+		}
+	}
+	return unindexTerms(txn, customer.ID)
+}
+
+func getCustomer(txn *memdb.Txn, id int) (*serve.Customer, error) {
+	raw, err := txn.First(customerTableName, "id", id)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, serve.ErrNotFound
+	}
+	return raw.(*serve.Customer), nil
+}
+
+// putCustomer - writes the customer along with everything derived from it,
+// i.e. its segment memberships and search terms. The terms only depend on the attributes,
+// so they are left alone by writes that don't change them, like tracked events.
+func putCustomer(txn *memdb.Txn, customer *serve.Customer) error {
+	existing, err := getCustomer(txn, customer.ID)
+	if err != nil && !serve.IsNotFound(err) {
+		return err
+	}
+
+	if err := txn.Insert(customerTableName, customer); err != nil {
+		return err
+	}
+	if err := refreshMemberships(txn, customer); err != nil {
+		return err
+	}
+	if existing == nil {
+		return indexTerms(txn, nil, customer)
+	}
+	if sameAttributes(existing.Attributes, customer.Attributes) {
+		return nil
+	}
+	return indexTerms(txn, existing.Attributes, customer)
 }
 
 // TotalCustomers - it iterates over all entries and returns a total count
@@ -184,7 +625,7 @@ func (d Datastore) Delete(id int) error {
 func (d Datastore) TotalCustomers() (int, error) {
 	var count = 0
 
-	txn := d.customers.Txn(false)
+	txn := d.db.Txn(false)
 	defer txn.Abort()
 
 	// Get all iterator
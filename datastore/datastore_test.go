@@ -0,0 +1,487 @@
+package datastore_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/customerio/homework/datastore"
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
+
+const testCustomers = 50
+
+func newTestDatastore(t *testing.T) datastore.Datastore {
+	summary := summarize.New()
+
+	for i := 1; i <= testCustomers; i++ {
+		id := strconv.Itoa(i)
+		summary.Apply(&stream.Record{
+			Type:   summarize.TypeAttributes,
+			UserID: id,
+			Data: map[string]string{
+				"email":      fmt.Sprintf("customer%d@example.com", i),
+				"created_at": "1560964022",
+				"tier":       "A",
+			},
+			Timestamp: 1560964022,
+		})
+		for n := 0; n < i%5; n++ {
+			summary.Apply(&stream.Record{ID: fmt.Sprintf("%d-%d", i, n), Type: summarize.TypeEvent, Name: "purchase", UserID: id})
+		}
+	}
+
+	ds, err := datastore.CreateDatastore(summary)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	return ds
+}
+
+// read - touches every field of the customer the way the JSON encoder of the server does
+func read(t *testing.T, c *serve.Customer) {
+	if _, err := json.Marshal(c); err != nil {
+		t.Errorf("error encoding customer %d: %v", c.ID, err)
+	}
+}
+
+func TestUpdateDoesNotModifyReturnedCustomers(t *testing.T) {
+	ds := newTestDatastore(t)
+
+	before, err := ds.Get(1)
+	if err != nil {
+		t.Fatalf("error getting customer: %v", err)
+	}
+
+	if _, err := ds.Update(1, map[string]string{"tier": "S", "email": "new@example.com"}, []string{"created_at"}, 0); err != nil {
+		t.Fatalf("error updating customer: %v", err)
+	}
+
+	if before.Attributes["tier"] != "A" || before.Attributes["created_at"] == "" || before.Version != 1 {
+		t.Errorf("customer returned before the update was modified: %#v", before)
+	}
+
+	// the old email must be released from the index
+	if _, err := ds.GetByEmail("customer1@example.com"); !serve.IsNotFound(err) {
+		t.Errorf("old email still indexed, err: %v", err)
+	}
+	if _, _, err := ds.Create(0, map[string]string{"email": "customer1@example.com"}, false); err != nil {
+		t.Errorf("error reusing the old email: %v", err)
+	}
+}
+
+// TestConcurrentAccess - meant to be run with `go test -race`, readers hold on to customers
+// while writers update, patch, replace, delete and recreate them
+func TestConcurrentAccess(t *testing.T) {
+	ds := newTestDatastore(t)
+
+	if _, err := ds.CreateSegment("tier S", []serve.Condition{{Attribute: "tier", Operator: serve.OpEquals, Value: "S"}}); err != nil {
+		t.Fatalf("error creating segment: %v", err)
+	}
+
+	const workers = 4
+	const iterations = 200
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+
+			for i := 0; i < iterations; i++ {
+				id := rnd.Intn(testCustomers) + 1
+				tier := []string{"A", "B", "S"}[rnd.Intn(3)]
+
+				var err error
+				switch rnd.Intn(5) {
+				case 0:
+					_, err = ds.Update(id, map[string]string{"tier": tier}, nil, 0)
+				case 1:
+					_, err = ds.Patch(id, []serve.PatchOperation{{Op: "add", Path: "/attributes/tier", Value: json.RawMessage(strconv.Quote(tier))}}, 0)
+				case 2:
+					_, err = ds.Replace(id, map[string]string{"email": fmt.Sprintf("customer%d@example.com", id), "tier": tier}, 0)
+				case 3:
+					err = ds.Delete(id, 0)
+				case 4:
+					_, _, err = ds.Create(id, map[string]string{"email": fmt.Sprintf("customer%d@example.com", id), "tier": tier}, true)
+				}
+				if err != nil && !serve.IsNotFound(err) {
+					t.Errorf("write failed: %v", err)
+				}
+			}
+		}(int64(w))
+
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+
+			for i := 0; i < iterations; i++ {
+				cs, err := ds.List(rnd.Intn(5)+1, 10)
+				if err != nil {
+					t.Errorf("list failed: %v", err)
+				}
+				for _, c := range cs {
+					read(t, c)
+				}
+
+				if c, err := ds.Get(rnd.Intn(testCustomers) + 1); err == nil {
+					read(t, c)
+				}
+
+				if cs, _, err := ds.Search("example", 1, 10); err == nil {
+					for _, c := range cs {
+						read(t, c)
+					}
+				}
+
+				if cs, _, err := ds.SegmentCustomers(1, 1, 10); err == nil {
+					for _, c := range cs {
+						read(t, c)
+					}
+				}
+			}
+		}(int64(w + workers))
+	}
+
+	wg.Wait()
+
+	// segment membership must have been kept in line with the customers
+	members, total, err := ds.SegmentCustomers(1, 1, testCustomers)
+	if err != nil {
+		t.Fatalf("error listing segment: %v", err)
+	}
+	if len(members) != total {
+		t.Errorf("segment has %d members but lists %d", total, len(members))
+	}
+	for _, c := range members {
+		if c.Attributes["tier"] != "S" {
+			t.Errorf("customer %d in segment with tier %q", c.ID, c.Attributes["tier"])
+		}
+	}
+
+	all, err := ds.List(1, testCustomers)
+	if err != nil {
+		t.Fatalf("error listing customers: %v", err)
+	}
+	inSegment := 0
+	for _, c := range all {
+		if c.Attributes["tier"] == "S" {
+			inSegment++
+		}
+	}
+	if inSegment != total {
+		t.Errorf("%d customers have tier S, segment has %d", inSegment, total)
+	}
+}
+
+func TestIngest(t *testing.T) {
+	ds := newTestDatastore(t)
+
+	records := []*stream.Record{
+		{ID: "e1", Type: summarize.TypeEvent, Name: "purchase", UserID: "1"},
+		{ID: "e1", Type: summarize.TypeEvent, Name: "purchase", UserID: "1"},
+		// counted once the customer is identified
+		{ID: "e2", Type: summarize.TypeEvent, Name: "signup", UserID: "100"},
+		{Type: summarize.TypeAttributes, UserID: "100", Data: map[string]string{"email": "new@example.com", "city": "oslo"}, Timestamp: 20},
+		// older than what the customer has, only new keys are kept
+		{Type: summarize.TypeAttributes, UserID: "100", Data: map[string]string{"city": "bergen", "zip": "0150"}, Timestamp: 10},
+		{Type: summarize.TypeAttributes, UserID: "101", Data: map[string]string{"email": "NEW@example.com"}, Timestamp: 10},
+	}
+
+	results, err := ds.Ingest(records)
+	if err != nil {
+		t.Fatalf("error ingesting: %v", err)
+	}
+
+	if results[0].Customer == nil || results[0].Customer.Events["purchase"] != 2 {
+		t.Errorf("event not counted: %#v", results[0])
+	}
+	if !results[1].Duplicate {
+		t.Errorf("duplicate event not detected: %#v", results[1])
+	}
+	if results[2].Customer != nil || results[2].Err != nil {
+		t.Errorf("event of an unknown customer: %#v", results[2])
+	}
+	if !serve.IsConflict(results[5].Err) {
+		t.Errorf("email conflict not detected: %#v", results[5])
+	}
+
+	c, err := ds.Get(100)
+	if err != nil {
+		t.Fatalf("error getting customer: %v", err)
+	}
+	want := map[string]string{"email": "new@example.com", "city": "oslo", "zip": "0150"}
+	if !reflect.DeepEqual(c.Attributes, want) || c.Events["signup"] != 1 || c.LastUpdated != 20 {
+		t.Errorf("customer doesn't match: %#v", c)
+	}
+}
+
+func TestIngestOrder(t *testing.T) {
+	records := []*stream.Record{
+		{ID: "a1", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "bill@example.com", "plan": "free"}, Timestamp: 100},
+		{ID: "a2", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"city": "oslo"}, Timestamp: 200},
+		{ID: "a3", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"plan": "pro"}, Timestamp: 150},
+		{ID: "a0", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"plan": "trial", "city": "rome"}, Timestamp: 150},
+	}
+
+	file := summarize.New()
+	for _, rec := range records {
+		file.Apply(rec)
+	}
+	want := file.Users["1"].Attributes
+
+	// every order of the records, tracked one at a time, merges to what the whole file does
+	var permute func(n int)
+	permute = func(n int) {
+		if n == 1 {
+			ds, err := datastore.CreateDatastore(summarize.New())
+			if err != nil {
+				t.Fatalf("error creating datastore: %v", err)
+			}
+			for _, rec := range records {
+				if _, err := ds.Ingest([]*stream.Record{rec}); err != nil {
+					t.Fatalf("error ingesting: %v", err)
+				}
+			}
+			c, err := ds.Get(1)
+			if err != nil {
+				t.Fatalf("error getting customer: %v", err)
+			}
+			if !reflect.DeepEqual(c.Attributes, want) {
+				t.Errorf("order %s %s %s %s: attributes %v, want %v", records[0].ID, records[1].ID, records[2].ID, records[3].ID, c.Attributes, want)
+			}
+			return
+		}
+		for i := 0; i < n; i++ {
+			permute(n - 1)
+			j := 0
+			if n%2 == 0 {
+				j = i
+			}
+			records[j], records[n-1] = records[n-1], records[j]
+		}
+	}
+	permute(len(records))
+
+	// an edit through the API makes the attributes it changes as recent as the edit, the others keep their version
+	ds, err := datastore.CreateDatastore(file)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	if _, err := ds.Update(1, map[string]string{"city": "bergen"}, nil, 0); err != nil {
+		t.Fatalf("error updating: %v", err)
+	}
+	if _, err := ds.Ingest([]*stream.Record{
+		{ID: "a4", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"plan": "basic", "city": "paris"}, Timestamp: 300},
+	}); err != nil {
+		t.Fatalf("error ingesting: %v", err)
+	}
+	c, err := ds.Get(1)
+	if err != nil {
+		t.Fatalf("error getting customer: %v", err)
+	}
+	if c.Attributes["plan"] != "basic" || c.Attributes["city"] != "bergen" {
+		t.Errorf("attributes after an edit %v, want the plan tracked and the city edited", c.Attributes)
+	}
+}
+
+func TestIngestAggregates(t *testing.T) {
+	aggs, err := summarize.ParseAggregations("sum(purchase.price),max(purchase.price)")
+	if err != nil {
+		t.Fatalf("error parsing aggregations: %v", err)
+	}
+	summary := summarize.New()
+	summary.Aggregations = aggs
+	summary.Apply(&stream.Record{ID: "e1", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Data: map[string]string{"price": "5"}})
+
+	ds, err := datastore.CreateDatastore(summary)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+
+	// aggregated while the customer is pending, and then once identified
+	_, err = ds.Ingest([]*stream.Record{
+		{ID: "e2", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Data: map[string]string{"price": "7"}},
+		{Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "a@example.com"}, Timestamp: 10},
+		{ID: "e3", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Data: map[string]string{"price": "1.5"}},
+	})
+	if err != nil {
+		t.Fatalf("error ingesting: %v", err)
+	}
+
+	c, err := ds.Get(1)
+	if err != nil {
+		t.Fatalf("error getting customer: %v", err)
+	}
+	want := map[string]float64{"sum(purchase.price)": 13.5, "max(purchase.price)": 7}
+	if !reflect.DeepEqual(c.Aggregates, want) || c.Events["purchase"] != 3 {
+		t.Errorf("customer doesn't match: %#v", c)
+	}
+
+	// kept when the attributes are replaced
+	if c, err = ds.Replace(1, map[string]string{"email": "b@example.com"}, 0); err != nil || !reflect.DeepEqual(c.Aggregates, want) {
+		t.Errorf("aggregates not kept: %#v, %v", c, err)
+	}
+}
+
+func TestWindowedSegment(t *testing.T) {
+	now := time.Now().Unix()
+	summary := summarize.New()
+	summary.HistogramDays = 30
+	for _, rec := range []*stream.Record{
+		{Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "a@example.com"}, Timestamp: now},
+		{Type: summarize.TypeAttributes, UserID: "2", Data: map[string]string{"email": "b@example.com"}, Timestamp: now},
+		{ID: "e1", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Timestamp: now - 2*24*60*60},
+		{ID: "e2", Type: summarize.TypeEvent, Name: "purchase", UserID: "2", Timestamp: now - 20*24*60*60},
+	} {
+		summary.Apply(rec)
+	}
+
+	ds, err := datastore.CreateDatastore(summary)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+
+	recent, err := ds.CreateSegment("recent", []serve.Condition{{Event: "purchase", Operator: serve.OpGreaterOrEq, Value: "1", WithinDays: 7}})
+	if err != nil {
+		t.Fatalf("error creating segment: %v", err)
+	}
+	lapsed, err := ds.CreateSegment("lapsed", []serve.Condition{{Event: "purchase", Operator: serve.OpLess, Value: "1", WithinDays: 7}})
+	if err != nil {
+		t.Fatalf("error creating segment: %v", err)
+	}
+
+	for segment, want := range map[int]int{recent.ID: 1, lapsed.ID: 2} {
+		customers, total, err := ds.SegmentCustomers(segment, 1, 10)
+		if err != nil || total != 1 || len(customers) != 1 || customers[0].ID != want {
+			t.Errorf("segment %d: want customer %d, have %v (%d), %v", segment, want, customers, total, err)
+		}
+
+		var ids []int
+		err = ds.Each(serve.CustomerFilter{Segment: segment}, func(c *serve.Customer) error {
+			ids = append(ids, c.ID)
+			return nil
+		})
+		if err != nil || !reflect.DeepEqual(ids, []int{want}) {
+			t.Errorf("segment %d: want customer %d, have %v, %v", segment, want, ids, err)
+		}
+	}
+
+	// the histograms don't count events of more days than kept
+	long := []serve.Condition{{Event: "purchase", Operator: serve.OpGreaterOrEq, Value: "1", WithinDays: 31}}
+	if _, err := ds.CreateSegment("long", long); !errors.Is(err, serve.ErrWindowTooLong) {
+		t.Errorf("window longer than the histogram created: %v", err)
+	}
+	if _, err := ds.UpdateSegment(recent.ID, "long", long); !errors.Is(err, serve.ErrWindowTooLong) {
+		t.Errorf("window longer than the histogram updated: %v", err)
+	}
+
+	// members are stored again once the window is dropped
+	if _, err := ds.UpdateSegment(recent.ID, "buyers", []serve.Condition{{Event: "purchase", Operator: serve.OpGreaterOrEq, Value: "1"}}); err != nil {
+		t.Fatalf("error updating segment: %v", err)
+	}
+	if customers, total, err := ds.SegmentCustomers(recent.ID, 1, 10); err != nil || total != 2 || len(customers) != 2 {
+		t.Errorf("segment without a window: want 2 customers, have %v (%d), %v", customers, total, err)
+	}
+}
+
+func TestGetAsOf(t *testing.T) {
+	if _, err := newTestDatastore(t).GetAsOf(1, 1560964022); !errors.Is(err, serve.ErrNoHistory) {
+		t.Errorf("history isn't kept by default: %v", err)
+	}
+	if _, err := datastore.NewMock(summarize.New()).GetAsOf(1, 1560964022); !errors.Is(err, serve.ErrNoHistory) {
+		t.Errorf("mock: history isn't kept by default: %v", err)
+	}
+
+	summary := summarize.New()
+	summary.KeepHistory()
+	for _, rec := range []*stream.Record{
+		{ID: "a1", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "a@example.com", "plan": "free"}, Timestamp: 100},
+		{ID: "e1", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Timestamp: 150},
+		{ID: "e1", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Timestamp: 150},
+		{ID: "a2", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"plan": "pro"}, Timestamp: 200},
+	} {
+		summary.Apply(rec)
+	}
+
+	mem, err := datastore.CreateDatastore(summary)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+
+	for name, ds := range map[string]serve.Datastore{"memdb": mem, "mock": datastore.NewMock(summary)} {
+		before, err := ds.GetAsOf(1, 300)
+		if err != nil {
+			t.Fatalf("%s: error getting customer: %v", name, err)
+		}
+
+		// tracked records are part of the history too
+		_, err = ds.Ingest([]*stream.Record{{ID: "e2", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Timestamp: 300}})
+		if err != nil {
+			t.Fatalf("%s: error ingesting: %v", name, err)
+		}
+
+		for asOf, want := range map[int64]*serve.Customer{
+			99:  nil,
+			100: {ID: 1, Attributes: map[string]string{"email": "a@example.com", "plan": "free"}, Events: map[string]int{}, LastUpdated: 100},
+			199: {ID: 1, Attributes: map[string]string{"email": "a@example.com", "plan": "free"}, Events: map[string]int{"purchase": 1}, LastUpdated: 100},
+			300: {ID: 1, Attributes: map[string]string{"email": "a@example.com", "plan": "pro"}, Events: map[string]int{"purchase": 2}, LastUpdated: 200},
+		} {
+			have, err := ds.GetAsOf(1, asOf)
+			if want == nil {
+				if !serve.IsNotFound(err) {
+					t.Errorf("%s: as of %d: customer before its first attributes: %#v, %v", name, asOf, have, err)
+				}
+				continue
+			}
+			if have != nil {
+				// the versions of attributes aren't served
+				have.Versions = nil
+			}
+			if err != nil || !reflect.DeepEqual(have, want) {
+				t.Errorf("%s: as of %d: customer doesn't match\nwant: %#v\nhave: %#v, %v", name, asOf, want, have, err)
+			}
+		}
+
+		if before.Events["purchase"] != 1 {
+			t.Errorf("%s: a customer read before the record was tracked changed: %#v", name, before)
+		}
+		if _, err := ds.GetAsOf(9, 300); !serve.IsNotFound(err) {
+			t.Errorf("%s: unknown customer: %v", name, err)
+		}
+
+		// the history goes with the customer, a customer created with the id has its own
+		if err := ds.Delete(1, 0); err != nil {
+			t.Fatalf("%s: error deleting: %v", name, err)
+		}
+		if c, err := ds.GetAsOf(1, 300); !serve.IsNotFound(err) {
+			t.Errorf("%s: deleted customer as of 300: %#v, %v", name, c, err)
+		}
+		_, err = ds.Ingest([]*stream.Record{{ID: "a3", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "b@example.com"}, Timestamp: 400}})
+		if err != nil {
+			t.Fatalf("%s: error ingesting: %v", name, err)
+		}
+		if c, err := ds.GetAsOf(1, 300); !serve.IsNotFound(err) {
+			t.Errorf("%s: re-created customer as of 300: %#v, %v", name, c, err)
+		}
+		if c, err := ds.GetAsOf(1, 400); err != nil || !reflect.DeepEqual(c.Attributes, map[string]string{"email": "b@example.com"}) || len(c.Events) != 0 {
+			t.Errorf("%s: re-created customer as of 400: %#v, %v", name, c, err)
+		}
+	}
+
+	// neither of them appends to the summarizer's array, past the length of its history
+	if h := summary.History["1"]; len(h) != 3 || cap(h) > len(h) && h[:len(h)+1][len(h)] != nil {
+		t.Errorf("the history of the summarizer changed: %d records", len(h))
+	}
+}
@@ -0,0 +1,78 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/customerio/homework/serve"
+	"github.com/hashicorp/go-memdb"
+)
+
+// emailIndex - indexes customers by their normalized email attribute,
+// customers without an email are left out of the index
+type emailIndex struct{}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (e *emailIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	customer, ok := obj.(*serve.Customer)
+	if !ok {
+		return false, nil, fmt.Errorf("email index expects a *serve.Customer, got %T", obj)
+	}
+
+	email := normalizeEmail(customer.Attributes["email"])
+	if email == "" {
+		return false, nil, nil
+	}
+
+	// null terminate like memdb.StringFieldIndex does
+	return true, []byte(email + "\x00"), nil
+}
+
+func (e *emailIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	email, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a string: %#v", args[0])
+	}
+	return []byte(normalizeEmail(email) + "\x00"), nil
+}
+
+// checkEmail - memdb doesn't enforce unique indexes on insert, so make sure
+// no other customer owns the email before writing customer `id`
+func checkEmail(txn *memdb.Txn, id int, email string) error {
+	if normalizeEmail(email) == "" {
+		return nil
+	}
+
+	raw, err := txn.First(customerTableName, "email", email)
+	if err != nil {
+		return err
+	}
+	if raw != nil && raw.(*serve.Customer).ID != id {
+		return &serve.ConflictError{Field: "email", Value: email}
+	}
+	return nil
+}
+
+func (d Datastore) GetByEmail(email string) (*serve.Customer, error) {
+	txn := d.db.Txn(false)
+	defer txn.Abort()
+
+	if normalizeEmail(email) == "" {
+		return nil, serve.ErrNotFound
+	}
+
+	raw, err := txn.First(customerTableName, "email", email)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, serve.ErrNotFound
+	}
+	return raw.(*serve.Customer), nil
+}
@@ -0,0 +1,76 @@
+package datastore_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/customerio/homework/datastore"
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
+
+func TestEmails(t *testing.T) {
+	summary := summarize.New()
+	for _, rec := range []*stream.Record{
+		{Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "bill@example.com"}, Timestamp: 10},
+		// the same email, more recently, wins it
+		{Type: summarize.TypeAttributes, UserID: "2", Data: map[string]string{"email": "Bill@Example.com "}, Timestamp: 20},
+		{Type: summarize.TypeAttributes, UserID: "3", Data: map[string]string{"email": "ann@example.com"}, Timestamp: 5},
+	} {
+		summary.Apply(rec)
+	}
+
+	ds, err := datastore.CreateDatastore(summary)
+	if err != nil {
+		t.Fatalf("duplicate emails must not stop the load: %v", err)
+	}
+	if _, err := ds.Get(1); !serve.IsNotFound(err) {
+		t.Errorf("customer with the email of a more recent one loaded: %v", err)
+	}
+
+	// looked up case insensitively
+	for _, email := range []string{"bill@example.com", "BILL@example.com", " bill@example.com"} {
+		if c, err := ds.GetByEmail(email); err != nil || c.ID != 2 {
+			t.Errorf("%q: %v, %v", email, c, err)
+		}
+	}
+	if _, err := ds.GetByEmail("nobody@example.com"); !serve.IsNotFound(err) {
+		t.Errorf("unknown email found: %v", err)
+	}
+
+	var ce *serve.ConflictError
+	writes := map[string]func() error{
+		"create": func() error {
+			_, _, err := ds.Create(0, map[string]string{"email": "ANN@example.com"}, false)
+			return err
+		},
+		"update": func() error {
+			_, err := ds.Update(2, map[string]string{"email": "ann@example.com"}, nil, 0)
+			return err
+		},
+		"replace": func() error {
+			_, err := ds.Replace(2, map[string]string{"email": "ann@example.com"}, 0)
+			return err
+		},
+		"identify": func() error {
+			results, err := ds.Ingest([]*stream.Record{{Type: summarize.TypeAttributes, UserID: "4", Data: map[string]string{"email": "ann@example.com"}}})
+			if err != nil {
+				return err
+			}
+			return results[0].Err
+		},
+	}
+	for name, write := range writes {
+		if err := write(); !serve.IsConflict(err) {
+			t.Errorf("%s: taken email not a conflict: %v", name, err)
+		} else if errors.As(err, &ce) && ce.Field != "email" {
+			t.Errorf("%s: conflict on %q", name, ce.Field)
+		}
+	}
+
+	// a customer keeps its own email
+	if _, err := ds.Update(3, map[string]string{"email": "Ann@example.com"}, nil, 0); err != nil {
+		t.Errorf("error updating to the same email: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package datastore
+
+import (
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+	"github.com/hashicorp/go-memdb"
+)
+
+// customerHistory - the records applied to a customer, in order. The records a stored history holds,
+// up to its length, are never modified.
+type customerHistory struct {
+	CustomerID int
+	Records    []*stream.Record
+}
+
+// appendHistory - stores the history of the customer with rec appended.
+// Histories only grow, so a new one shares the array of the stored one: append only writes past the length
+// of the stored slice, which readers of it never look at, and memdb has a single writer at a time. When a
+// txn is aborted the next append writes over the same slot. Appending is amortized O(1) as with any slice.
+func appendHistory(txn *memdb.Txn, id int, rec *stream.Record) error {
+	var records []*stream.Record
+	raw, err := txn.First(historyTableName, "id", id)
+	if err != nil {
+		return err
+	}
+	if raw != nil {
+		records = raw.(*customerHistory).Records
+	}
+
+	return txn.Insert(historyTableName, &customerHistory{CustomerID: id, Records: append(records, rec)})
+}
+
+// GetAsOf - replays the history of the customer up to asOf, only customers that exist now have one
+func (d Datastore) GetAsOf(id int, asOf int64) (*serve.Customer, error) {
+	if !d.history {
+		return nil, serve.ErrNoHistory
+	}
+
+	txn := d.db.Txn(false)
+	defer txn.Abort()
+
+	if _, err := getCustomer(txn, id); err != nil {
+		return nil, err
+	}
+	raw, err := txn.First(historyTableName, "id", id)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, serve.ErrNotFound
+	}
+
+	return customerAsOf(id, raw.(*customerHistory).Records, asOf, d.config)
+}
+
+// customerAsOf - replays the records of the customer up to asOf, fails with serve.ErrNotFound if it wasn't
+// identified by then. The customer has no version, it isn't one that can be written.
+func customerAsOf(id int, records []*stream.Record, asOf int64, config summarize.Config) (*serve.Customer, error) {
+	summary := summarize.AsOf(records, summarize.Cutoff{Timestamp: asOf}, config)
+	if !summary.Identified() {
+		return nil, serve.ErrNotFound
+	}
+	return customerFromSummary(id, summary, 0), nil
+}
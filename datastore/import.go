@@ -0,0 +1,86 @@
+package datastore
+
+import (
+	"time"
+
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/utils"
+	"github.com/hashicorp/go-memdb"
+)
+
+// Import - writes all the customers in a single txn, a customer that can't be written is reported
+// in its own error and doesn't stop the others
+func (d Datastore) Import(customers []*serve.Customer) ([]error, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+
+	errs := make([]error, len(customers))
+	for i, customer := range customers {
+		if _, err := importCustomer(txn, customer); err != nil {
+			if !isOperationError(err) {
+				return nil, err
+			}
+			errs[i] = err
+		}
+	}
+
+	txn.Commit()
+	return errs, nil
+}
+
+// importCustomer - upserts a complete customer, i.e. its attributes, event counts and aggregates are replaced.
+// Imports carry no counts of averaged values, so an imported avg starts over with the next value tracked.
+func importCustomer(txn *memdb.Txn, c *serve.Customer) (*serve.Customer, error) {
+	id := c.ID
+
+	var err error
+	if id == 0 {
+		if id, err = peekID(txn, customerSequence); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkEmail(txn, id, c.Attributes["email"]); err != nil {
+		return nil, err
+	}
+	if err := advanceSequence(txn, customerSequence, id); err != nil {
+		return nil, err
+	}
+
+	existing, err := getCustomer(txn, id)
+	if err != nil && !serve.IsNotFound(err) {
+		return nil, err
+	}
+
+	events := make(map[string]int, len(c.Events))
+	for name, count := range c.Events {
+		events[name] = count
+	}
+
+	var aggregates map[string]float64
+	if c.Aggregates != nil {
+		aggregates = make(map[string]float64, len(c.Aggregates))
+		for name, value := range c.Aggregates {
+			aggregates[name] = value
+		}
+	}
+
+	version, err := nextVersion(txn, id, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	customer := &serve.Customer{
+		ID:          id,
+		Attributes:  utils.CopyMap(c.Attributes),
+		Events:      events,
+		LastUpdated: int(time.Now().Unix()),
+		Aggregates:  aggregates,
+		Version:     version,
+	}
+
+	if err := putCustomer(txn, customer); err != nil {
+		return nil, err
+	}
+	return customer, nil
+}
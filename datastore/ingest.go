@@ -0,0 +1,227 @@
+package datastore
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+	"github.com/hashicorp/go-memdb"
+)
+
+// seenEvent - the id of an event that has been counted
+type seenEvent struct {
+	ID string
+}
+
+// seenEvents - the summarize.EventSet of the events counted by the datastore
+type seenEvents struct {
+	txn *memdb.Txn
+}
+
+func (s seenEvents) Add(id string) (bool, error) {
+	seen, err := s.txn.First(eventTableName, "id", id)
+	if err != nil || seen != nil {
+		return false, err
+	}
+	return true, s.txn.Insert(eventTableName, &seenEvent{ID: id})
+}
+
+// pendingEvents - event counts, aggregates and histogram of a customer that hasn't been identified yet,
+// like stored customers they are never modified in place
+type pendingEvents struct {
+	CustomerID int
+	Events     map[string]int
+	Aggregates map[string]float64
+	Averaged   map[string]int
+	Histogram  summarize.Histogram
+}
+
+// newPendingEvents - the events summarized for a customer that hasn't been identified yet
+func newPendingEvents(id int, summary *summarize.Summary) *pendingEvents {
+	return &pendingEvents{
+		CustomerID: id,
+		Events:     summary.Events,
+		Aggregates: summary.Aggregates,
+		Averaged:   summary.Averaged,
+		Histogram:  summary.Histogram,
+	}
+}
+
+// Ingest - a record that can't be applied is reported in its result and doesn't stop the others
+func (d Datastore) Ingest(records []*stream.Record) ([]serve.IngestResult, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+
+	results := make([]serve.IngestResult, len(records))
+	for i, rec := range records {
+		result, err := ingestRecord(txn, rec, d.history, d.config)
+		if err != nil && !isOperationError(err) {
+			return nil, err
+		}
+		result.Err = err
+		results[i] = result
+	}
+
+	txn.Commit()
+	return results, nil
+}
+
+// ingestRecord - applies the record, appending it to the history of the customer when history is set
+func ingestRecord(txn *memdb.Txn, rec *stream.Record, history bool, config summarize.Config) (serve.IngestResult, error) {
+	id, err := recordCustomerID(rec)
+	if err != nil {
+		return serve.IngestResult{}, err
+	}
+
+	if duplicate, err := summarize.Dedup(rec, seenEvents{txn}); err != nil || duplicate {
+		return serve.IngestResult{Duplicate: duplicate}, err
+	}
+
+	existing, err := getCustomer(txn, id)
+	if err != nil && !serve.IsNotFound(err) {
+		return serve.IngestResult{}, err
+	}
+	var pending *pendingEvents
+	if existing == nil {
+		if pending, err = pendingEventsOf(txn, id); err != nil {
+			return serve.IngestResult{}, err
+		}
+	}
+
+	summary := summaryOf(existing, pending, config)
+	summary.Apply(rec)
+
+	if !summary.Identified() {
+		if err := txn.Insert(pendingTableName, newPendingEvents(id, summary)); err != nil {
+			return serve.IngestResult{}, err
+		}
+		if history {
+			return serve.IngestResult{}, appendHistory(txn, id, rec)
+		}
+		return serve.IngestResult{}, nil
+	}
+
+	// checked before anything is written, the txn carries on with the next record when it fails
+	if err := checkEmail(txn, id, summary.Attributes["email"]); err != nil {
+		return serve.IngestResult{}, err
+	}
+
+	version, err := nextVersion(txn, id, existing)
+	if err != nil {
+		return serve.IngestResult{}, err
+	}
+
+	customer := customerFromSummary(id, summary, version)
+	if existing == nil {
+		if err := advanceSequence(txn, customerSequence, id); err != nil {
+			return serve.IngestResult{}, err
+		}
+		if _, err := txn.DeleteAll(pendingTableName, "id", id); err != nil {
+			return serve.IngestResult{}, err
+		}
+	}
+
+	// the event counts may change segment membership, putCustomer re-evaluates it
+	if err := putCustomer(txn, customer); err != nil {
+		return serve.IngestResult{}, err
+	}
+	if history {
+		if err := appendHistory(txn, id, rec); err != nil {
+			return serve.IngestResult{}, err
+		}
+	}
+	return serve.IngestResult{Customer: customer}, nil
+}
+
+// recordCustomerID - the id of the customer the record applies to, records that can't be applied are operation errors
+func recordCustomerID(rec *stream.Record) (int, error) {
+	id, err := strconv.Atoi(rec.UserID)
+	if err != nil || id <= 0 {
+		return 0, &operationError{fmt.Errorf("user_id %q is not a customer id", rec.UserID)}
+	}
+	if rec.Type != summarize.TypeEvent && rec.Type != summarize.TypeAttributes {
+		return 0, &operationError{fmt.Errorf("unknown record type %q", rec.Type)}
+	}
+	return id, nil
+}
+
+// summaryOf - a copy of the summary of a customer to apply records to, LastUpdated being the timestamp
+// of its attributes. For a customer that doesn't exist yet, the summary of its pending events if any.
+func summaryOf(customer *serve.Customer, pending *pendingEvents, config summarize.Config) *summarize.Summary {
+	summary := &summarize.Summary{Config: config}
+	if customer != nil {
+		c := customer.Clone()
+		summary.Attributes, summary.Events, summary.Timestamp = c.Attributes, c.Events, int64(c.LastUpdated)
+		summary.Versions, summary.Aggregates, summary.Averaged, summary.Histogram = c.Versions, c.Aggregates, c.Averaged, c.Histogram
+	} else if pending != nil {
+		p := pending.clone()
+		summary.Events, summary.Aggregates, summary.Averaged, summary.Histogram = p.Events, p.Aggregates, p.Averaged, p.Histogram
+	}
+	return summary
+}
+
+// customerFromSummary - the customer of an identified summary, at the given version. Events is never nil,
+// as expected by the verify-script.
+func customerFromSummary(id int, summary *summarize.Summary, version int) *serve.Customer {
+	events := summary.Events
+	if events == nil {
+		events = make(map[string]int)
+	}
+	return &serve.Customer{
+		ID:          id,
+		Attributes:  summary.Attributes,
+		Events:      events,
+		LastUpdated: int(summary.Timestamp),
+		Aggregates:  summary.Aggregates,
+		Averaged:    summary.Averaged,
+		Histogram:   summary.Histogram,
+		Versions:    summary.Versions,
+		Version:     version,
+	}
+}
+
+// pendingEventsOf - the events counted before the customer existed, nil if there are none
+func pendingEventsOf(txn *memdb.Txn, id int) (*pendingEvents, error) {
+	raw, err := txn.First(pendingTableName, "id", id)
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	return raw.(*pendingEvents), nil
+}
+
+// clone - a deep copy, Events is never nil
+func (p *pendingEvents) clone() *pendingEvents {
+	clone := &pendingEvents{CustomerID: p.CustomerID, Events: make(map[string]int, len(p.Events))}
+	for k, v := range p.Events {
+		clone.Events[k] = v
+	}
+	if p.Aggregates != nil {
+		clone.Aggregates = make(map[string]float64, len(p.Aggregates))
+		for k, v := range p.Aggregates {
+			clone.Aggregates[k] = v
+		}
+	}
+	if p.Averaged != nil {
+		clone.Averaged = make(map[string]int, len(p.Averaged))
+		for k, v := range p.Averaged {
+			clone.Averaged[k] = v
+		}
+	}
+	clone.Histogram = p.Histogram.Clone()
+	return clone
+}
+
+// takePendingEvents - removes and returns a copy of the events counted before the customer existed, nil if there are none
+func takePendingEvents(txn *memdb.Txn, id int) (*pendingEvents, error) {
+	pending, err := pendingEventsOf(txn, id)
+	if err != nil || pending == nil {
+		return nil, err
+	}
+
+	if _, err := txn.DeleteAll(pendingTableName, "id", id); err != nil {
+		return nil, err
+	}
+	return pending.clone(), nil
+}
@@ -2,11 +2,24 @@ package datastore
 
 import (
 	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+	"github.com/customerio/homework/utils"
 )
 
-type Mock struct{}
+// Mock - a naive serve.Datastore, the zero value serves two mock customers. Mocks made with NewMock
+// have a store of their own and behave like a Datastore made from the same summary.
+type Mock struct {
+	store *mockState
+}
+
+var _ serve.Datastore = Mock{}
 
 var mockCustomer1 = &serve.Customer{
 	ID: 1,
@@ -20,6 +33,7 @@ var mockCustomer1 = &serve.Customer{
 		"played_song": 5,
 	},
 	LastUpdated: 1625181700,
+	Version:     1,
 }
 
 var mockCustomer2 = &serve.Customer{
@@ -34,45 +48,568 @@ var mockCustomer2 = &serve.Customer{
 		"played_song": 1,
 	},
 	LastUpdated: 1625180000,
+	Version:     1,
+}
+
+// mockState - customers known to a Mock, along with the ids of the events tracked, the events
+// of customers not identified yet and the history of the records tracked
+type mockState struct {
+	sync.Mutex
+	// sequence - the last id handed out, like the customer sequence of a Datastore it only goes up
+	sequence  int
+	customers map[int]*serve.Customer
+	events    summarize.EventIDs
+	pending   map[int]*pendingEvents
+	// deleted - customer id -> last version of the deleted customer, see Datastore.nextVersion
+	deleted map[int]int
+	// config - of the summaries of the events tracked, like Datastore.config
+	config summarize.Config
+	// history - nil unless the summary the Mock was loaded from kept one, like Datastore.history
+	history map[int][]*stream.Record
+}
+
+func newMockState() *mockState {
+	return &mockState{
+		customers: make(map[int]*serve.Customer),
+		events:    make(summarize.EventIDs),
+		pending:   make(map[int]*pendingEvents),
+		deleted:   make(map[int]int),
+	}
+}
+
+// sharedMock - the store of the Mock zero value, shared by all of them
+var sharedMock = func() *mockState {
+	s := newMockState()
+	s.customers[mockCustomer1.ID] = mockCustomer1
+	s.customers[mockCustomer2.ID] = mockCustomer2
+	s.sequence = mockCustomer2.ID
+	return s
+}()
+
+// NewMock - a Mock with a store of its own, loaded from the summary like CreateDatastore loads a Datastore
+func NewMock(summary *summarize.Summarizer) Mock {
+	s := newMockState()
+	s.config = summary.Config
+	for _, k := range loadOrder(summary.Users) {
+		user := summary.Users[k]
+		id, err := strconv.Atoi(k)
+		if err != nil {
+			continue
+		}
+		if !user.Identified() {
+			s.pending[id] = newPendingEvents(id, user)
+			continue
+		}
+		// the email stays with the most recently updated customer, like CreateDatastore
+		if owner := s.emailOwner(user.Attributes["email"]); owner != nil {
+			continue
+		}
+
+		s.customers[id] = customerFromSummary(id, user, 1)
+		s.advance(id)
+	}
+	for id := range summary.EventIDs {
+		s.events[id] = true
+	}
+	if summary.History != nil {
+		s.history = make(map[int][]*stream.Record, len(summary.History))
+		for k, records := range summary.History {
+			if id, err := strconv.Atoi(k); err == nil {
+				// capped so appending copies rather than writing to the summarizer's array
+				s.history[id] = records[:len(records):len(records)]
+			}
+		}
+	}
+	return Mock{store: s}
+}
+
+// state - the store of the Mock
+func (m Mock) state() *mockState {
+	if m.store != nil {
+		return m.store
+	}
+	return sharedMock
+}
+
+// sortedCustomers - all customers sorted by id, the caller must hold the lock
+func (s *mockState) sortedCustomers() []*serve.Customer {
+	cs := make([]*serve.Customer, 0, len(s.customers))
+	for _, customer := range s.customers {
+		cs = append(cs, customer)
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].ID < cs[j].ID })
+	return cs
+}
+
+// emailOwner - the caller must hold the lock
+func (s *mockState) emailOwner(email string) *serve.Customer {
+	email = normalizeEmail(email)
+	if email == "" {
+		return nil
+	}
+	for _, customer := range s.customers {
+		if normalizeEmail(customer.Attributes["email"]) == email {
+			return customer
+		}
+	}
+	return nil
+}
+
+// checkEmail - like the checkEmail of a Datastore, the caller must hold the lock
+func (s *mockState) checkEmail(id int, email string) error {
+	if owner := s.emailOwner(email); owner != nil && owner.ID != id {
+		return &serve.ConflictError{Field: "email", Value: email}
+	}
+	return nil
+}
+
+// advance - like advanceSequence, the caller must hold the lock
+func (s *mockState) advance(id int) {
+	if id > s.sequence {
+		s.sequence = id
+	}
 }
 
 func (m Mock) Get(id int) (*serve.Customer, error) {
-	switch id {
-	case 1:
-		return mockCustomer1, nil
-	case 2:
-		return mockCustomer2, nil
-	default:
-		return nil, serve.ErrNotFound
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	if customer, prs := s.customers[id]; prs {
+		return customer, nil
 	}
+	return nil, serve.ErrNotFound
+}
+
+func (m Mock) GetByEmail(email string) (*serve.Customer, error) {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	if customer := s.emailOwner(email); customer != nil {
+		return customer, nil
+	}
+	return nil, serve.ErrNotFound
 }
 
 func (m Mock) List(page, count int) ([]*serve.Customer, error) {
-	return []*serve.Customer{mockCustomer1, mockCustomer2}, nil
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	return paginateMock(s.sortedCustomers(), page, count), nil
 }
 
-func (m Mock) Create(id int, attributes map[string]string) (*serve.Customer, error) {
-	return nil, errors.New("unimplemented")
+// Create behaves like Datastore.Create: an existing id is a conflict unless upserting,
+// a missing id is assigned
+func (m Mock) Create(id int, attributes map[string]string, upsert bool) (*serve.Customer, bool, error) {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	return s.create(id, attributes, upsert)
+}
+
+// create - the caller must hold the lock
+func (s *mockState) create(id int, attributes map[string]string, upsert bool) (*serve.Customer, bool, error) {
+	if id == 0 {
+		id = s.sequence + 1
+	}
+
+	existing, prs := s.customers[id]
+	if prs && !upsert {
+		return nil, false, &serve.ConflictError{Field: "id", Value: strconv.Itoa(id)}
+	}
+	if err := s.checkEmail(id, attributes["email"]); err != nil {
+		return nil, false, err
+	}
+	s.advance(id)
+
+	customer := &serve.Customer{
+		ID:          id,
+		Attributes:  utils.CopyMap(attributes),
+		Events:      nil,
+		LastUpdated: int(time.Now().Unix()),
+		Version:     s.nextVersion(id),
+	}
+	if prs {
+		clone := existing.Clone()
+		customer.Events, customer.Aggregates, customer.Averaged, customer.Histogram = clone.Events, clone.Aggregates, clone.Averaged, clone.Histogram
+	} else if pending, ok := s.pending[id]; ok {
+		pending = pending.clone()
+		customer.Events, customer.Aggregates, customer.Averaged, customer.Histogram = pending.Events, pending.Aggregates, pending.Averaged, pending.Histogram
+		delete(s.pending, id)
+	}
+
+	s.customers[id] = customer
+	return customer, !prs, nil
+}
+
+func (m Mock) Update(id int, attributes map[string]string, removed []string, version int) (*serve.Customer, error) {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	return s.write(id, version, mergeAttributes(attributes, removed))
 }
 
-// Update is intentionally naive
-func (m Mock) Update(id int, attributes map[string]string) (*serve.Customer, error) {
-	switch id {
-	case 1:
-		mockCustomer1.Attributes = attributes
-		return mockCustomer1, nil
-	case 2:
-		mockCustomer2.Attributes = attributes
-		return mockCustomer2, nil
-	default:
+func (m Mock) Patch(id int, ops []serve.PatchOperation, version int) (*serve.Customer, error) {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	return s.write(id, version, patchAttributes(ops))
+}
+
+func (m Mock) Replace(id int, attributes map[string]string, version int) (*serve.Customer, error) {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	return s.write(id, version, replaceAttributes(attributes))
+}
+
+// write - like writeCustomer, the caller must hold the lock
+func (s *mockState) write(id, version int, fn attributesFunc) (*serve.Customer, error) {
+	customer, prs := s.customers[id]
+	if !prs {
 		return nil, serve.ErrNotFound
 	}
+	if version != 0 && customer.Version != version {
+		return nil, serve.ErrPreconditionFailed
+	}
+
+	attributes, err := fn(customer)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkEmail(id, attributes["email"]); err != nil {
+		return nil, err
+	}
+
+	// copy-on-write, callers may still be reading the stored customer
+	updated := customer.Clone()
+	updated.Attributes = attributes
+	updated.Versions = unchangedVersions(customer, attributes)
+	updated.LastUpdated = int(time.Now().Unix())
+	updated.Version++
+
+	s.customers[updated.ID] = updated
+	return updated, nil
+}
+
+func (m Mock) Delete(id, version int) error {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	return s.delete(id, version)
 }
 
-func (m Mock) Delete(id int) error {
+// delete - like deleteCustomer, the caller must hold the lock
+func (s *mockState) delete(id, version int) error {
+	customer, prs := s.customers[id]
+	if !prs {
+		return serve.ErrNotFound
+	}
+	if version != 0 && customer.Version != version {
+		return serve.ErrPreconditionFailed
+	}
+	delete(s.customers, id)
+	delete(s.pending, id)
+	delete(s.history, id)
+	s.deleted[id] = customer.Version
 	return nil
 }
 
+// nextVersion - like Datastore.nextVersion, the caller must hold the lock
+func (s *mockState) nextVersion(id int) int {
+	if customer, prs := s.customers[id]; prs {
+		return customer.Version + 1
+	}
+	return s.deleted[id] + 1
+}
+
 func (m Mock) TotalCustomers() (int, error) {
-	return 2, nil
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.customers), nil
+}
+
+func (m Mock) ListSegments() ([]*serve.Segment, error) {
+	return []*serve.Segment{}, nil
+}
+
+func (m Mock) GetSegment(id int) (*serve.Segment, error) {
+	return nil, serve.ErrNotFound
+}
+
+func (m Mock) CreateSegment(name string, conditions []serve.Condition) (*serve.Segment, error) {
+	return nil, errors.New("unimplemented")
+}
+
+func (m Mock) UpdateSegment(id int, name string, conditions []serve.Condition) (*serve.Segment, error) {
+	return nil, serve.ErrNotFound
+}
+
+func (m Mock) DeleteSegment(id int) error {
+	return serve.ErrNotFound
+}
+
+func (m Mock) SegmentCustomers(id, page, count int) ([]*serve.Customer, int, error) {
+	return nil, 0, serve.ErrNotFound
+}
+
+func (m Mock) Search(query string, page, count int) ([]*serve.Customer, int, error) {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	// scored like Datastore.Search, from the terms of every customer rather than an index
+	tokens := tokenize(query)
+	scores := make(map[int]int)
+	for id, customer := range s.customers {
+		terms := termsOf(customer.Attributes)
+		for _, token := range tokens {
+			best := 0
+			for t := range terms {
+				if score := termScore(t, token); score > best {
+					best = score
+				}
+			}
+			scores[id] += best
+		}
+	}
+
+	cs := make([]*serve.Customer, 0)
+	for id, score := range scores {
+		if score > 0 {
+			cs = append(cs, s.customers[id])
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		if scores[cs[i].ID] != scores[cs[j].ID] {
+			return scores[cs[i].ID] > scores[cs[j].ID]
+		}
+		return cs[i].ID < cs[j].ID
+	})
+	return paginateMock(cs, page, count), len(cs), nil
+}
+
+func (m Mock) Ingest(records []*stream.Record) ([]serve.IngestResult, error) {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	results := make([]serve.IngestResult, len(records))
+	for i, rec := range records {
+		results[i] = s.ingest(rec)
+	}
+	return results, nil
+}
+
+// ingest - like ingestRecord, the caller must hold the lock
+func (s *mockState) ingest(rec *stream.Record) serve.IngestResult {
+	id, err := recordCustomerID(rec)
+	if err != nil {
+		return serve.IngestResult{Err: err}
+	}
+	if duplicate, _ := summarize.Dedup(rec, s.events); duplicate {
+		return serve.IngestResult{Duplicate: true}
+	}
+
+	existing := s.customers[id]
+	summary := summaryOf(existing, s.pending[id], s.config)
+	summary.Apply(rec)
+
+	if !summary.Identified() {
+		s.pending[id] = newPendingEvents(id, summary)
+		s.appendHistory(id, rec)
+		return serve.IngestResult{}
+	}
+	if err := s.checkEmail(id, summary.Attributes["email"]); err != nil {
+		return serve.IngestResult{Err: err}
+	}
+
+	customer := customerFromSummary(id, summary, s.nextVersion(id))
+	if existing == nil {
+		s.advance(id)
+		delete(s.pending, id)
+	}
+
+	s.customers[id] = customer
+	s.appendHistory(id, rec)
+	return serve.IngestResult{Customer: customer}
+}
+
+// appendHistory - when the history is kept, the caller must hold the lock
+func (s *mockState) appendHistory(id int, rec *stream.Record) {
+	if s.history != nil {
+		s.history[id] = append(s.history[id], rec)
+	}
+}
+
+// GetAsOf - only the records tracked through Ingest are part of the history
+func (m Mock) GetAsOf(id int, asOf int64) (*serve.Customer, error) {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	if s.history == nil {
+		return nil, serve.ErrNoHistory
+	}
+	if _, prs := s.customers[id]; !prs {
+		return nil, serve.ErrNotFound
+	}
+	records, prs := s.history[id]
+	if !prs {
+		return nil, serve.ErrNotFound
+	}
+
+	return customerAsOf(id, records, asOf, s.config)
+}
+
+func (m Mock) Each(filter serve.CustomerFilter, fn func(*serve.Customer) error) error {
+	s := m.state()
+	if filter.Segment != 0 {
+		return serve.ErrNotFound
+	}
+
+	s.Lock()
+	cs := s.sortedCustomers()
+	s.Unlock()
+
+	tokens := tokenize(filter.Query)
+	for _, customer := range cs {
+		if len(tokens) > 0 && !matchesQuery(customer, tokens) {
+			continue
+		}
+		if err := fn(customer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func paginateMock(cs []*serve.Customer, page, count int) []*serve.Customer {
+	start := (page - 1) * count
+	if start > len(cs) {
+		start = len(cs)
+	}
+	end := start + count
+	if end > len(cs) {
+		end = len(cs)
+	}
+	return cs[start:end]
+}
+
+// Batch holds the lock for the whole batch like a Datastore's write txn, an atomic batch that fails
+// is rolled back by restoring a snapshot of the store
+func (m Mock) Batch(ops []serve.BatchOperation, atomic bool) ([]serve.BatchResult, error) {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	var snapshot *mockState
+	if atomic {
+		snapshot = s.snapshot()
+	}
+
+	results := make([]serve.BatchResult, len(ops))
+	for i, op := range ops {
+		var result serve.BatchResult
+
+		switch op.Op {
+		case serve.BatchCreate:
+			result.Customer, result.Created, result.Err = s.create(op.ID, op.Attributes, op.Upsert)
+		case serve.BatchUpdate:
+			result.Customer, result.Err = s.write(op.ID, op.Version, mergeAttributes(op.Attributes, op.Removed))
+		case serve.BatchReplace:
+			result.Customer, result.Err = s.write(op.ID, op.Version, replaceAttributes(op.Attributes))
+		case serve.BatchDelete:
+			result.Err = s.delete(op.ID, op.Version)
+		default:
+			result.Err = errors.New("unknown operation " + op.Op)
+		}
+
+		results[i] = result
+		if result.Err != nil && atomic {
+			s.restore(snapshot)
+			for j := range results {
+				results[j].Customer, results[j].Created = nil, false
+			}
+			return results, nil
+		}
+	}
+	return results, nil
+}
+
+// snapshot - a copy of the store that restore can roll back to. Stored customers, pending events
+// and histories are never modified in place, so copying the maps is enough. The caller must hold the lock.
+func (s *mockState) snapshot() *mockState {
+	snapshot := &mockState{
+		sequence:  s.sequence,
+		customers: make(map[int]*serve.Customer, len(s.customers)),
+		events:    make(summarize.EventIDs, len(s.events)),
+		pending:   make(map[int]*pendingEvents, len(s.pending)),
+		deleted:   make(map[int]int, len(s.deleted)),
+	}
+	for id, customer := range s.customers {
+		snapshot.customers[id] = customer
+	}
+	for id := range s.events {
+		snapshot.events[id] = true
+	}
+	for id, pending := range s.pending {
+		snapshot.pending[id] = pending
+	}
+	for id, version := range s.deleted {
+		snapshot.deleted[id] = version
+	}
+	if s.history != nil {
+		snapshot.history = make(map[int][]*stream.Record, len(s.history))
+		for id, records := range s.history {
+			// capped so appending after the restore doesn't write over records appended since
+			snapshot.history[id] = records[:len(records):len(records)]
+		}
+	}
+	return snapshot
+}
+
+// restore - rolls the store back to the snapshot, the caller must hold the lock
+func (s *mockState) restore(snapshot *mockState) {
+	s.sequence, s.customers, s.events = snapshot.sequence, snapshot.customers, snapshot.events
+	s.pending, s.deleted, s.history = snapshot.pending, snapshot.deleted, snapshot.history
+}
+
+func (m Mock) Import(customers []*serve.Customer) ([]error, error) {
+	s := m.state()
+	s.Lock()
+	defer s.Unlock()
+
+	errs := make([]error, len(customers))
+	for i, c := range customers {
+		customer := c.Clone()
+		if customer.ID == 0 {
+			customer.ID = s.sequence + 1
+		}
+
+		if err := s.checkEmail(customer.ID, c.Attributes["email"]); err != nil {
+			errs[i] = err
+			continue
+		}
+		s.advance(customer.ID)
+
+		// like Datastore.Import, only attributes, event counts and aggregates are imported
+		customer.Averaged, customer.Histogram, customer.Versions = nil, summarize.Histogram{}, nil
+		if customer.Events == nil {
+			customer.Events = make(map[string]int)
+		}
+		customer.LastUpdated = int(time.Now().Unix())
+		customer.Version = s.nextVersion(customer.ID)
+		s.customers[customer.ID] = customer
+	}
+	return errs, nil
 }
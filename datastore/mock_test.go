@@ -0,0 +1,280 @@
+package datastore_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/customerio/homework/datastore"
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
+
+// parityStep - an operation run against every datastore, its result is compared as JSON
+type parityStep struct {
+	name string
+	run  func(ds serve.Datastore) (interface{}, error)
+}
+
+// errorClass - errors are compared by what the API makes of them
+func errorClass(err error) string {
+	var pe serve.PatchErrors
+	switch {
+	case err == nil:
+		return ""
+	case serve.IsNotFound(err):
+		return "not found"
+	case serve.IsConflict(err):
+		return "conflict"
+	case errors.Is(err, serve.ErrPreconditionFailed):
+		return "precondition failed"
+	case errors.Is(err, serve.ErrNoHistory):
+		return "no history"
+	case errors.As(err, &pe):
+		return "patch"
+	}
+	return "error"
+}
+
+// TestMockParity - the Mock must behave like a Datastore loaded from the same summary
+func TestMockParity(t *testing.T) {
+	started := time.Now().Unix()
+
+	newSummary := func() *summarize.Summarizer {
+		summary := summarize.New()
+		summary.Aggregations = []summarize.Aggregation{
+			{Func: summarize.AggregateSum, Event: "purchase", Key: "price"},
+			{Func: summarize.AggregateAvg, Event: "purchase", Key: "price"},
+		}
+		summary.HistogramDays = 2
+		summary.KeepHistory()
+		for _, rec := range []*stream.Record{
+			{ID: "a1", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "bill@example.com", "city": "oslo"}, Timestamp: 100},
+			{ID: "a2", Type: summarize.TypeAttributes, UserID: "2", Data: map[string]string{"email": "ann@example.com"}, Timestamp: 100},
+			// left out, customer 1 was updated more recently
+			{ID: "a0", Type: summarize.TypeAttributes, UserID: "3", Data: map[string]string{"email": " Bill@Example.com"}, Timestamp: 90},
+			{ID: "e1", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Data: map[string]string{"price": "4"}, Timestamp: 110},
+			// not identified yet
+			{ID: "e2", Type: summarize.TypeEvent, Name: "purchase", UserID: "5", Data: map[string]string{"price": "2"}, Timestamp: 120},
+		} {
+			summary.Apply(rec)
+		}
+		return summary
+	}
+
+	mem, err := datastore.CreateDatastore(newSummary())
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	stores := map[string]serve.Datastore{"memdb": mem, "mock": datastore.NewMock(newSummary())}
+
+	ingest := func(records ...*stream.Record) func(ds serve.Datastore) (interface{}, error) {
+		return func(ds serve.Datastore) (interface{}, error) {
+			results, err := ds.Ingest(records)
+			type result struct {
+				Customer  *serve.Customer
+				Duplicate bool
+				Err       string
+			}
+			var out []result
+			for _, r := range results {
+				out = append(out, result{r.Customer, r.Duplicate, errorClass(r.Err)})
+			}
+			return out, err
+		}
+	}
+
+	create := func(id int, attributes map[string]string, upsert bool) func(ds serve.Datastore) (interface{}, error) {
+		return func(ds serve.Datastore) (interface{}, error) {
+			customer, created, err := ds.Create(id, attributes, upsert)
+			return struct {
+				Customer *serve.Customer
+				Created  bool
+			}{customer, created}, err
+		}
+	}
+
+	search := func(ds serve.Datastore, query string) (interface{}, error) {
+		cs, total, err := ds.Search(query, 1, 10)
+		return struct {
+			Customers []*serve.Customer
+			Total     int
+		}{cs, total}, err
+	}
+
+	batch := func(ops []serve.BatchOperation, atomic bool) func(ds serve.Datastore) (interface{}, error) {
+		return func(ds serve.Datastore) (interface{}, error) {
+			results, err := ds.Batch(ops, atomic)
+			type result struct {
+				Customer *serve.Customer
+				Created  bool
+				Err      string
+			}
+			var out []result
+			for _, r := range results {
+				out = append(out, result{r.Customer, r.Created, errorClass(r.Err)})
+			}
+			return out, err
+		}
+	}
+
+	steps := []parityStep{
+		{"get", func(ds serve.Datastore) (interface{}, error) { return ds.Get(1) }},
+		{"get unknown", func(ds serve.Datastore) (interface{}, error) { return ds.Get(9) }},
+		{"get by email", func(ds serve.Datastore) (interface{}, error) { return ds.GetByEmail(" BILL@example.com") }},
+		{"total", func(ds serve.Datastore) (interface{}, error) { return ds.TotalCustomers() }},
+		{"list", func(ds serve.Datastore) (interface{}, error) { return ds.List(1, 10) }},
+		{"search a prefix", func(ds serve.Datastore) (interface{}, error) { return search(ds, "bil") }},
+		{"search inside a word", func(ds serve.Datastore) (interface{}, error) { return search(ds, "ill") }},
+		{"search ranks", func(ds serve.Datastore) (interface{}, error) { return search(ds, "example oslo") }},
+		{"create taken id", create(1, map[string]string{"email": "x@example.com"}, false)},
+		{"create taken email", create(0, map[string]string{"email": "ann@example.com"}, false)},
+		{"create assigns the next id", create(0, map[string]string{"email": "zoe@example.com"}, false)},
+		{"create with pending events", create(5, map[string]string{"email": "five@example.com"}, false)},
+		{"upsert keeps the events", create(1, map[string]string{"email": "bill@example.com", "city": "rome"}, true)},
+		{"update", func(ds serve.Datastore) (interface{}, error) {
+			return ds.Update(1, map[string]string{"zip": "0150"}, []string{"city"}, 2)
+		}},
+		{"update stale version", func(ds serve.Datastore) (interface{}, error) {
+			return ds.Update(1, map[string]string{"zip": "0151"}, nil, 2)
+		}},
+		{"update unknown", func(ds serve.Datastore) (interface{}, error) {
+			return ds.Update(9, map[string]string{"zip": "0151"}, nil, 0)
+		}},
+		{"patch", func(ds serve.Datastore) (interface{}, error) {
+			return ds.Patch(2, []serve.PatchOperation{{Op: "add", Path: "/attributes/city", Value: json.RawMessage(`"bergen"`)}}, 0)
+		}},
+		{"invalid patch", func(ds serve.Datastore) (interface{}, error) {
+			return ds.Patch(2, []serve.PatchOperation{{Op: "remove", Path: "/attributes/nope"}}, 0)
+		}},
+		{"replace", func(ds serve.Datastore) (interface{}, error) {
+			return ds.Replace(2, map[string]string{"email": "ann@example.com", "plan": "pro"}, 0)
+		}},
+		{"replace with a taken email", func(ds serve.Datastore) (interface{}, error) {
+			return ds.Replace(2, map[string]string{"email": "bill@example.com"}, 0)
+		}},
+		{"track", ingest(
+			&stream.Record{ID: "e3", Type: summarize.TypeEvent, Name: "signup", UserID: "1", Timestamp: 130},
+			&stream.Record{ID: "e1", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Timestamp: 110},
+			&stream.Record{ID: "e4", Type: summarize.TypeEvent, Name: "signup", UserID: "7", Timestamp: 130},
+			&stream.Record{ID: "e6", Type: summarize.TypeEvent, Name: "purchase", UserID: "5", Data: map[string]string{"price": "7"}, Timestamp: 2 * 86400},
+			&stream.Record{ID: "e7", Type: summarize.TypeEvent, Name: "purchase", UserID: "7", Data: map[string]string{"price": "3"}, Timestamp: 140},
+			&stream.Record{ID: "e8", Type: summarize.TypeEvent, Name: "signup", UserID: "11", Timestamp: 140},
+		)},
+		{"track for ids that aren't customer ids", ingest(
+			&stream.Record{ID: "e9", Type: summarize.TypeEvent, Name: "signup", UserID: "0", Timestamp: 140},
+			&stream.Record{ID: "a9", Type: summarize.TypeAttributes, UserID: "-2", Data: map[string]string{"email": "neg@example.com"}, Timestamp: 140},
+			&stream.Record{ID: "e9", Type: summarize.TypeEvent, Name: "signup", UserID: "x", Timestamp: 140},
+			&stream.Record{ID: "e9", Type: summarize.TypeEvent, Name: "signup", UserID: "1", Timestamp: 140},
+		)},
+		{"histogram", func(ds serve.Datastore) (interface{}, error) {
+			c, err := ds.Get(5)
+			if err != nil {
+				return nil, err
+			}
+			return c.Histogram.Buckets(summarize.IntervalDay)
+		}},
+		{"identify", ingest(
+			&stream.Record{ID: "a3", Type: summarize.TypeAttributes, UserID: "7", Data: map[string]string{"email": "seven@example.com"}, Timestamp: 140},
+			&stream.Record{ID: "a4", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"zip": "0999"}, Timestamp: 50},
+			&stream.Record{ID: "a5", Type: summarize.TypeAttributes, UserID: "8", Data: map[string]string{"email": "ann@example.com"}, Timestamp: 150},
+			&stream.Record{ID: "x1", Type: "unknown", UserID: "8"},
+		)},
+		{"pending aggregates", func(ds serve.Datastore) (interface{}, error) { return ds.Get(7) }},
+		{"identify out of order", ingest(
+			&stream.Record{ID: "a6", Type: summarize.TypeAttributes, UserID: "7", Data: map[string]string{"city": "oslo"}, Timestamp: 200},
+			&stream.Record{ID: "a7", Type: summarize.TypeAttributes, UserID: "7", Data: map[string]string{"email": "seven@corp.example.com"}, Timestamp: 170},
+		)},
+		{"track for a customer created through the API", ingest(
+			&stream.Record{ID: "e5", Type: summarize.TypeEvent, Name: "signup", UserID: "3", Timestamp: 160},
+		)},
+		{"batch", batch([]serve.BatchOperation{
+			{Op: serve.BatchUpdate, ID: 2, Attributes: map[string]string{"plan": "free"}},
+			{Op: serve.BatchDelete, ID: 9},
+		}, false)},
+		{"atomic batch", batch([]serve.BatchOperation{
+			{Op: serve.BatchUpdate, ID: 2, Attributes: map[string]string{"plan": "gold"}},
+			{Op: serve.BatchCreate, ID: 11, Attributes: map[string]string{"email": "eleven@example.com"}},
+			{Op: serve.BatchDelete, ID: 1},
+			{Op: serve.BatchCreate, Attributes: map[string]string{"email": "twelve@example.com"}},
+			{Op: serve.BatchDelete, ID: 9},
+		}, true)},
+		{"after the atomic batch", func(ds serve.Datastore) (interface{}, error) { return ds.Get(2) }},
+		{"history after the atomic batch", func(ds serve.Datastore) (interface{}, error) { return ds.GetAsOf(1, 1<<40) }},
+		{"pending events after the atomic batch", create(11, map[string]string{"email": "eleven@example.com"}, false)},
+		{"ids after the atomic batch", create(0, map[string]string{"email": "twelve@example.com"}, false)},
+		{"import", func(ds serve.Datastore) (interface{}, error) {
+			errs, err := ds.Import([]*serve.Customer{
+				{ID: 2, Attributes: map[string]string{"email": "ann@example.com"}, Events: map[string]int{"purchase": 3}, Aggregates: map[string]float64{"sum(purchase.price)": 9}},
+				{ID: 20, Attributes: map[string]string{"email": "bill@example.com"}},
+			})
+			var classes []string
+			for _, err := range errs {
+				classes = append(classes, errorClass(err))
+			}
+			return classes, err
+		}},
+		{"imported", func(ds serve.Datastore) (interface{}, error) { return ds.Get(2) }},
+		{"delete stale version", func(ds serve.Datastore) (interface{}, error) { return nil, ds.Delete(2, 1) }},
+		{"delete", func(ds serve.Datastore) (interface{}, error) { return nil, ds.Delete(2, 0) }},
+		{"delete unknown", func(ds serve.Datastore) (interface{}, error) { return nil, ds.Delete(2, 0) }},
+		{"re-create keeps the version going", create(2, map[string]string{"email": "ann@example.com"}, false)},
+		{"delete the highest id", func(ds serve.Datastore) (interface{}, error) { return nil, ds.Delete(12, 0) }},
+		{"deleted ids aren't assigned again", create(0, map[string]string{"email": "next@example.com"}, false)},
+		{"everything", func(ds serve.Datastore) (interface{}, error) {
+			var cs []*serve.Customer
+			err := ds.Each(serve.CustomerFilter{}, func(c *serve.Customer) error {
+				cs = append(cs, c)
+				return nil
+			})
+			return cs, err
+		}},
+	}
+
+	for _, step := range steps {
+		results := make(map[string]string)
+		for name, ds := range stores {
+			value, err := step.run(ds)
+			raw, jerr := json.Marshal(value)
+			if jerr != nil {
+				t.Fatalf("%s: %s: error encoding result: %v", step.name, name, jerr)
+			}
+			results[name] = errorClass(err) + " " + normalizeTimes(t, raw, started)
+		}
+		if results["memdb"] != results["mock"] {
+			t.Errorf("%s: the mock differs\nmemdb: %s\nmock:  %s", step.name, results["memdb"], results["mock"])
+		}
+	}
+}
+
+// normalizeTimes - replaces the last_updated of writes made now, which may be a second apart
+func normalizeTimes(t *testing.T, raw []byte, since int64) string {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatalf("error decoding result: %v", err)
+	}
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, x := range v {
+				if n, ok := x.(float64); ok && (k == "last_updated" || k == "LastUpdated") && int64(n) >= since {
+					v[k] = "now"
+					continue
+				}
+				walk(x)
+			}
+		case []interface{}:
+			for _, x := range v {
+				walk(x)
+			}
+		}
+	}
+	walk(v)
+
+	out, _ := json.Marshal(v)
+	return string(out)
+}
@@ -0,0 +1,183 @@
+package datastore
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/customerio/homework/serve"
+	"github.com/hashicorp/go-memdb"
+)
+
+const (
+	// a query token equal to an indexed term scores higher than one only prefixing it
+	exactMatchScore  = 2
+	prefixMatchScore = 1
+)
+
+// term - an entry of the inverted index, a lower cased word found in one of the customer's attribute values
+type term struct {
+	Term       string
+	CustomerID int
+}
+
+// tokenize - splits a value into lower cased words, the whole value is kept as a term too
+// so that partial emails like `bill@gm` still match as a prefix
+func tokenize(value string) []string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return nil
+	}
+
+	words := strings.FieldsFunc(value, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if len(words) == 1 && words[0] == value {
+		return words
+	}
+	return append(words, value)
+}
+
+// termsOf - the terms attribute values are indexed under
+func termsOf(attributes map[string]string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, value := range attributes {
+		for _, t := range tokenize(value) {
+			terms[t] = true
+		}
+	}
+	return terms
+}
+
+// indexTerms - updates the terms of the customer in the inverted index, previous being the attributes
+// they were indexed for (nil if none) so that only the terms that changed are written
+func indexTerms(txn *memdb.Txn, previous map[string]string, customer *serve.Customer) error {
+	before, after := termsOf(previous), termsOf(customer.Attributes)
+
+	for t := range before {
+		if after[t] {
+			continue
+		}
+		if err := txn.Delete(termTableName, &term{Term: t, CustomerID: customer.ID}); err != nil {
+			return err
+		}
+	}
+	for t := range after {
+		if before[t] {
+			continue
+		}
+		if err := txn.Insert(termTableName, &term{Term: t, CustomerID: customer.ID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameAttributes - whether the customer would be indexed under the same terms
+func sameAttributes(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if value, ok := b[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func unindexTerms(txn *memdb.Txn, customerID int) error {
+	_, err := txn.DeleteAll(termTableName, "customer", customerID)
+	return err
+}
+
+// termScore - how well a query token matches an indexed term, 0 if it doesn't
+func termScore(t, token string) int {
+	switch {
+	case t == token:
+		return exactMatchScore
+	case strings.HasPrefix(t, token):
+		return prefixMatchScore
+	}
+	return 0
+}
+
+// matchesQuery - whether Search would find the customer for the tokens of a query,
+// i.e. one of them prefixes a term of its attribute values
+func matchesQuery(customer *serve.Customer, tokens []string) bool {
+	for _, value := range customer.Attributes {
+		for _, t := range tokenize(value) {
+			for _, token := range tokens {
+				if strings.HasPrefix(t, token) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Search - looks up customers by (partial) words of their attribute values, the best matches come first
+func (d Datastore) Search(query string, page, count int) ([]*serve.Customer, int, error) {
+	var start = (page - 1) * count
+	var end = start + count
+
+	txn := d.db.Txn(false)
+	defer txn.Abort()
+
+	// customer id -> score
+	scores := make(map[int]int)
+
+	for _, token := range tokenize(query) {
+		// best score of this token for every customer it matches
+		matched := make(map[int]int)
+
+		it, err := txn.Get(termTableName, "term_prefix", token)
+		if err != nil {
+			return nil, 0, err
+		}
+		for obj := it.Next(); obj != nil; obj = it.Next() {
+			t := obj.(*term)
+			if score := termScore(t.Term, token); score > matched[t.CustomerID] {
+				matched[t.CustomerID] = score
+			}
+		}
+
+		for id, score := range matched {
+			scores[id] += score
+		}
+	}
+
+	ids := make([]int, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	total := len(ids)
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+
+	cs := make([]*serve.Customer, 0, end-start)
+	for _, id := range ids[start:end] {
+		raw, err := txn.First(customerTableName, "id", id)
+		if err != nil {
+			return nil, 0, err
+		}
+		if raw != nil {
+			cs = append(cs, raw.(*serve.Customer))
+		}
+	}
+
+	return cs, total, nil
+}
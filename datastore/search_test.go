@@ -0,0 +1,79 @@
+package datastore_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/customerio/homework/datastore"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
+
+func TestSearch(t *testing.T) {
+	summary := summarize.New()
+	for id, attributes := range map[string]map[string]string{
+		"1": {"email": "bill@gmail.com", "first_name": "Bill"},
+		"2": {"email": "billy@example.com", "first_name": "Billy"},
+		"3": {"email": "ann@gmail.com", "city": "Billings"},
+		"4": {"email": "zoe@example.com"},
+	} {
+		summary.Apply(&stream.Record{Type: summarize.TypeAttributes, UserID: id, Data: attributes, Timestamp: 10})
+	}
+	ds, err := datastore.CreateDatastore(summary)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+
+	var tests = []struct {
+		query string
+		want  []int
+	}{
+		// exact words rank above prefixes, ties by id
+		{"bill", []int{1, 2, 3}},
+		{"BILLY", []int{2}},
+		// the partial email ranks first, its words match the others
+		{"bill@gm", []int{1, 3, 2}},
+		{"gmail", []int{1, 3}},
+		// every token adds to the score
+		{"bill gmail", []int{1, 3, 2}},
+		{"nobody", []int{}},
+		{"", []int{}},
+	}
+	for _, tt := range tests {
+		cs, total, err := ds.Search(tt.query, 1, 10)
+		if err != nil {
+			t.Fatalf("%q: error searching: %v", tt.query, err)
+		}
+		ids := []int{}
+		for _, c := range cs {
+			ids = append(ids, c.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) || total != len(tt.want) {
+			t.Errorf("%q\nwant: %v\nhave: %v (total %d)", tt.query, tt.want, ids, total)
+		}
+	}
+
+	if cs, total, _ := ds.Search("bill", 2, 2); total != 3 || len(cs) != 1 || cs[0].ID != 3 {
+		t.Errorf("second page doesn't match: %v, total %d", cs, total)
+	}
+
+	// writes keep the index in line
+	if _, err := ds.Update(2, map[string]string{"first_name": "William"}, []string{"email"}, 0); err != nil {
+		t.Fatalf("error updating customer: %v", err)
+	}
+	if err := ds.Delete(3, 0); err != nil {
+		t.Fatalf("error deleting customer: %v", err)
+	}
+	// tracked events leave the terms alone, attributes tracked are indexed
+	if _, err := ds.Ingest([]*stream.Record{
+		{ID: "e1", Type: summarize.TypeEvent, Name: "login", UserID: "1", Timestamp: 20},
+		{Type: summarize.TypeAttributes, UserID: "4", Data: map[string]string{"first_name": "Wilma"}, Timestamp: 20},
+	}); err != nil {
+		t.Fatalf("error ingesting: %v", err)
+	}
+	for query, want := range map[string]int{"bill": 1, "william": 1, "billings": 0, "wil": 2} {
+		if _, total, _ := ds.Search(query, 1, 10); total != want {
+			t.Errorf("%q after writes: %d customers, want %d", query, total, want)
+		}
+	}
+}
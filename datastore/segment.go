@@ -0,0 +1,263 @@
+package datastore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/customerio/homework/serve"
+	"github.com/hashicorp/go-memdb"
+)
+
+// membership - a customer being part of a segment, kept up to date on every customer write
+type membership struct {
+	SegmentID  int
+	CustomerID int
+}
+
+func (d Datastore) ListSegments() ([]*serve.Segment, error) {
+	txn := d.db.Txn(false)
+	defer txn.Abort()
+
+	it, err := txn.Get(segmentTableName, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]*serve.Segment, 0)
+	for obj := it.Next(); obj != nil; obj = it.Next() {
+		segments = append(segments, obj.(*serve.Segment))
+	}
+	return segments, nil
+}
+
+func (d Datastore) GetSegment(id int) (*serve.Segment, error) {
+	txn := d.db.Txn(false)
+	defer txn.Abort()
+
+	return getSegment(txn, id)
+}
+
+func (d Datastore) CreateSegment(name string, conditions []serve.Condition) (*serve.Segment, error) {
+	if err := d.checkWindows(conditions); err != nil {
+		return nil, err
+	}
+
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+
+	// ids of deleted segments aren't handed out again
+	id, err := peekID(txn, segmentSequence)
+	if err != nil {
+		return nil, err
+	}
+	if err := advanceSequence(txn, segmentSequence, id); err != nil {
+		return nil, err
+	}
+
+	segment := &serve.Segment{
+		ID:          id,
+		Name:        name,
+		Conditions:  conditions,
+		LastUpdated: int(time.Now().Unix()),
+	}
+
+	if err := txn.Insert(segmentTableName, segment); err != nil {
+		return nil, err
+	}
+	if err := rebuildMembership(txn, segment); err != nil {
+		return nil, err
+	}
+
+	txn.Commit()
+	return segment, nil
+}
+
+func (d Datastore) UpdateSegment(id int, name string, conditions []serve.Condition) (*serve.Segment, error) {
+	if err := d.checkWindows(conditions); err != nil {
+		return nil, err
+	}
+
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+
+	if _, err := getSegment(txn, id); err != nil {
+		return nil, err
+	}
+
+	segment := &serve.Segment{
+		ID:          id,
+		Name:        name,
+		Conditions:  conditions,
+		LastUpdated: int(time.Now().Unix()),
+	}
+
+	if err := txn.Insert(segmentTableName, segment); err != nil {
+		return nil, err
+	}
+	if err := rebuildMembership(txn, segment); err != nil {
+		return nil, err
+	}
+
+	txn.Commit()
+	return segment, nil
+}
+
+func (d Datastore) DeleteSegment(id int) error {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+
+	segment, err := getSegment(txn, id)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.Delete(segmentTableName, segment); err != nil {
+		return err
+	}
+	if _, err := txn.DeleteAll(membershipTableName, "segment", id); err != nil {
+		return err
+	}
+
+	txn.Commit()
+	return nil
+}
+
+func (d Datastore) SegmentCustomers(id, page, count int) ([]*serve.Customer, int, error) {
+	var start = ((page - 1) * count) + 1
+	var end = start + count - 1
+	var total = 0
+
+	txn := d.db.Txn(false)
+	defer txn.Abort()
+
+	segment, err := getSegment(txn, id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// memberships are evaluated on writes, customers can enter or leave a time window without one
+	// so segments with windows are evaluated against every customer instead
+	var it memdb.ResultIterator
+	if segment.Windowed() {
+		it, err = txn.Get(customerTableName, "id")
+	} else {
+		it, err = txn.Get(membershipTableName, "segment", id)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cs := make([]*serve.Customer, 0, count)
+	for obj := it.Next(); obj != nil; obj = it.Next() {
+		customer, ok := obj.(*serve.Customer)
+		if ok && !segment.Matches(customer) {
+			continue
+		}
+
+		total++
+		if total < start || total > end {
+			continue
+		}
+
+		if !ok {
+			raw, err := txn.First(customerTableName, "id", obj.(*membership).CustomerID)
+			if err != nil {
+				return nil, 0, err
+			}
+			if raw == nil {
+				continue
+			}
+			customer = raw.(*serve.Customer)
+		}
+		cs = append(cs, customer)
+	}
+
+	return cs, total, nil
+}
+
+// checkWindows - a condition can't count the events of more days than the histograms of customers keep
+func (d Datastore) checkWindows(conditions []serve.Condition) error {
+	for _, cd := range conditions {
+		if cd.WithinDays > d.config.HistogramDays {
+			return fmt.Errorf("%w: %d, only %d are kept", serve.ErrWindowTooLong, cd.WithinDays, d.config.HistogramDays)
+		}
+	}
+	return nil
+}
+
+func getSegment(txn *memdb.Txn, id int) (*serve.Segment, error) {
+	raw, err := txn.First(segmentTableName, "id", id)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, serve.ErrNotFound
+	}
+	return raw.(*serve.Segment), nil
+}
+
+// rebuildMembership - recomputes the members of a segment from scratch, used when
+// the segment itself is created or its conditions change. Windowed segments have no members
+// stored, see SegmentCustomers.
+func rebuildMembership(txn *memdb.Txn, segment *serve.Segment) error {
+	if _, err := txn.DeleteAll(membershipTableName, "segment", segment.ID); err != nil {
+		return err
+	}
+	if segment.Windowed() {
+		return nil
+	}
+
+	it, err := txn.Get(customerTableName, "id")
+	if err != nil {
+		return err
+	}
+
+	// collect first, so the customer table isn't iterated while the txn is being written to
+	var members []int
+	for obj := it.Next(); obj != nil; obj = it.Next() {
+		if customer := obj.(*serve.Customer); segment.Matches(customer) {
+			members = append(members, customer.ID)
+		}
+	}
+
+	for _, id := range members {
+		if err := txn.Insert(membershipTableName, &membership{SegmentID: segment.ID, CustomerID: id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshMemberships - incrementally re-evaluates every segment without a window against a single
+// customer that was just written in the txn
+func refreshMemberships(txn *memdb.Txn, customer *serve.Customer) error {
+	it, err := txn.Get(segmentTableName, "id")
+	if err != nil {
+		return err
+	}
+
+	var segments []*serve.Segment
+	for obj := it.Next(); obj != nil; obj = it.Next() {
+		if segment := obj.(*serve.Segment); !segment.Windowed() {
+			segments = append(segments, segment)
+		}
+	}
+
+	for _, segment := range segments {
+		existing, err := txn.First(membershipTableName, "id", segment.ID, customer.ID)
+		if err != nil {
+			return err
+		}
+
+		switch matches := segment.Matches(customer); {
+		case matches && existing == nil:
+			err = txn.Insert(membershipTableName, &membership{SegmentID: segment.ID, CustomerID: customer.ID})
+		case !matches && existing != nil:
+			err = txn.Delete(membershipTableName, existing)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
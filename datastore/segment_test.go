@@ -0,0 +1,110 @@
+package datastore_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
+
+// members - the ids of the customers of the segment
+func members(t *testing.T, ds serve.Datastore, segment int) []int {
+	t.Helper()
+
+	cs, total, err := ds.SegmentCustomers(segment, 1, 100)
+	if err != nil {
+		t.Fatalf("error listing segment %d: %v", segment, err)
+	}
+	if total != len(cs) {
+		t.Errorf("segment %d has %d members but lists %d", segment, total, len(cs))
+	}
+	ids := []int{}
+	for _, c := range cs {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func TestSegmentMembership(t *testing.T) {
+	ds := newTestDatastore(t)
+
+	// customers 4, 9, 14 ... have 4 purchases
+	buyers, err := ds.CreateSegment("buyers", []serve.Condition{
+		{Event: "purchase", Operator: serve.OpGreaterOrEq, Value: "4"},
+		{Attribute: "tier", Operator: serve.OpEquals, Value: "A"},
+	})
+	if err != nil {
+		t.Fatalf("error creating segment: %v", err)
+	}
+	if have, want := members(t, ds, buyers.ID), []int{4, 9, 14, 19, 24, 29, 34, 39, 44, 49}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("members on create\nwant: %v\nhave: %v", want, have)
+	}
+
+	steps := []struct {
+		name  string
+		write func() error
+		want  []int
+	}{
+		{"update leaves", func() error {
+			_, err := ds.Update(9, map[string]string{"tier": "B"}, nil, 0)
+			return err
+		}, []int{4, 14, 19, 24, 29, 34, 39, 44, 49}},
+		{"replace leaves", func() error {
+			_, err := ds.Replace(14, map[string]string{"email": "customer14@example.com"}, 0)
+			return err
+		}, []int{4, 19, 24, 29, 34, 39, 44, 49}},
+		{"delete leaves", func() error {
+			return ds.Delete(4, 0)
+		}, []int{19, 24, 29, 34, 39, 44, 49}},
+		{"update joins", func() error {
+			_, err := ds.Update(9, map[string]string{"tier": "A"}, nil, 0)
+			return err
+		}, []int{9, 19, 24, 29, 34, 39, 44, 49}},
+		{"event joins", func() error {
+			_, err := ds.Ingest([]*stream.Record{{ID: "p1", Type: summarize.TypeEvent, Name: "purchase", UserID: "3"}})
+			return err
+		}, []int{3, 9, 19, 24, 29, 34, 39, 44, 49}},
+		{"created customer is evaluated", func() error {
+			_, _, err := ds.Create(4, map[string]string{"email": "customer4@example.com", "tier": "A"}, false)
+			return err
+		}, []int{3, 9, 19, 24, 29, 34, 39, 44, 49}},
+		{"upsert keeps the events", func() error {
+			_, _, err := ds.Create(19, map[string]string{"email": "customer19@example.com", "tier": "A", "city": "oslo"}, true)
+			return err
+		}, []int{3, 9, 19, 24, 29, 34, 39, 44, 49}},
+	}
+	for _, step := range steps {
+		if err := step.write(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if have := members(t, ds, buyers.ID); !reflect.DeepEqual(have, step.want) {
+			t.Errorf("%s\nwant: %v\nhave: %v", step.name, step.want, have)
+		}
+	}
+
+	// changed conditions are evaluated against every customer
+	if _, err := ds.UpdateSegment(buyers.ID, "buyers", []serve.Condition{{Event: "purchase", Operator: serve.OpEquals, Value: "1"}}); err != nil {
+		t.Fatalf("error updating segment: %v", err)
+	}
+	if have, want := members(t, ds, buyers.ID), []int{1, 6, 11, 16, 21, 26, 31, 36, 41, 46}; !reflect.DeepEqual(have, want) {
+		t.Errorf("members on update\nwant: %v\nhave: %v", want, have)
+	}
+
+	if err := ds.DeleteSegment(buyers.ID); err != nil {
+		t.Fatalf("error deleting segment: %v", err)
+	}
+	if _, _, err := ds.SegmentCustomers(buyers.ID, 1, 10); !serve.IsNotFound(err) {
+		t.Errorf("deleted segment still listed: %v", err)
+	}
+
+	// the id of the deleted segment isn't handed out again
+	next, err := ds.CreateSegment("next", []serve.Condition{{Attribute: "tier", Operator: serve.OpEquals, Value: "A"}})
+	if err != nil {
+		t.Fatalf("error creating segment: %v", err)
+	}
+	if next.ID <= buyers.ID {
+		t.Errorf("segment %d created after deleting segment %d", next.ID, buyers.ID)
+	}
+}
@@ -0,0 +1,43 @@
+package datastore
+
+import (
+	"github.com/hashicorp/go-memdb"
+)
+
+const (
+	customerSequence = "customer"
+	segmentSequence  = "segment"
+)
+
+// sequence - the last id handed out for a table, kept in memdb so that an
+// aborted txn doesn't burn ids
+type sequence struct {
+	Name  string
+	Value int
+}
+
+// peekID - the next id of the sequence, it is only allocated by advanceSequence so that a write
+// failing its checks doesn't burn it, even in a txn that is committed
+func peekID(txn *memdb.Txn, name string) (int, error) {
+	raw, err := txn.First(sequenceTableName, "id", name)
+	if err != nil {
+		return 0, err
+	}
+	if raw == nil {
+		return 1, nil
+	}
+	return raw.(*sequence).Value + 1, nil
+}
+
+// advanceSequence - makes sure the sequence never hands out an id that was picked explicitly
+func advanceSequence(txn *memdb.Txn, name string, id int) error {
+	raw, err := txn.First(sequenceTableName, "id", name)
+	if err != nil {
+		return err
+	}
+	if raw != nil && raw.(*sequence).Value >= id {
+		return nil
+	}
+
+	return txn.Insert(sequenceTableName, &sequence{Name: name, Value: id})
+}
@@ -43,13 +43,18 @@ export default {
     })
   },
 
-  patchCustomerAttributes({state, commit}, { attributes, id }) {
+  // PUT replaces the whole attribute set, so attributes removed in the edit page are deleted.
+  // If-Match makes the server reject the save (412) if someone else changed the customer meanwhile
+  replaceCustomerAttributes({state, commit}, { attributes, id }) {
+    const current = state.customers[id]
+    const headers = current ? {'If-Match': `"${current.version}"`} : {}
+
     return new Promise((resolve, reject) => {
-      this.$axios.patch(`/customers/${id}`, {
+      this.$axios.put(`/customers/${id}`, {
         customer: {
           attributes
         }
-      })
+      }, {headers})
         .then((resp) => {
           const customer = resp.data.customer
           commit('setItem', {id: customer.id, item: customer, resource: 'customers'})
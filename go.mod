@@ -8,6 +8,8 @@ require (
 	github.com/hashicorp/go-memdb v1.3.2
 	github.com/labstack/echo v3.3.10+incompatible
 	github.com/labstack/gommon v0.3.0
+	github.com/linkedin/goavro/v2 v2.10.0
 	github.com/valyala/fasttemplate v1.2.1 // indirect
+	github.com/xitongsys/parquet-go v1.6.0
 	golang.org/x/crypto v0.0.0-20210503195802-e9a32991a82e // indirect
 )
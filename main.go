@@ -1,21 +1,78 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"syscall"
-	"time"
 
 	"github.com/customerio/homework/datastore"
 	"github.com/customerio/homework/serve"
 	"github.com/customerio/homework/stream"
-	"github.com/customerio/homework/utils"
+	"github.com/customerio/homework/summarize"
 )
 
+const defaultMessagesFile = "data/messages.1.data"
+
+var (
+	addr        = flag.String("addr", ":1323", "address the server listens on")
+	maxLineSize = flag.Int("max-line-size", stream.DefaultMaxLineSize, "longest line of the messages file, in bytes")
+	format      = flag.String("format", "", "format of the messages file: ndjson, csv, avro or parquet (default from its extension, ndjson for stdin)")
+	late        = flag.String("late", "accept", "what to do with messages older than the watermark: accept, drop or divert")
+	lateness    = flag.Duration("lateness", 0, "how much older than the most recent message a message can be before it is late, 0 to not track late messages")
+	lateOutput  = flag.String("late-output", "late.ndjson", "file the late messages are written to with -late=divert")
+	asOf        = flag.String("as-of", "", "only summarize the messages up to this unix timestamp or ISO 8601 time")
+	asOfPos     = flag.Int64("as-of-position", 0, "only summarize the messages up to this position: byte offset for ndjson, record number otherwise")
+	history     = flag.Bool("history", false, "keep the messages of every customer in memory for GET /customers/:id?as_of=")
+	histogram   = flag.Int("histogram-days", 90, "days of daily event counts kept per customer, up to their most recent event, for the histogram endpoint and within_days segments of serve; 0 to keep none")
+	aggregate   = flag.String("aggregate", "", "comma separated aggregations of numeric event data, like sum(purchase.price),max(page.duration): sum, min, max, avg or count")
+	csvColumns  = flag.String("csv-columns", "", "columns of CSV messages whose header differs from the field names, like user_id=uid,timestamp=ts: fields are id, type, name, user_id and timestamp")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: homework [serve] [flags] [file]
+       homework summarize [flags] [file]
+
+Summarizes the messages file, - reads the messages from stdin (default %s).
+serve serves the customers API from the summary, summarize prints it in the
+format of the verify files instead, one customer per line.
+
+flags:
+`, defaultMessagesFile)
+	flag.PrintDefaults()
+}
+
 func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	cmd := "serve"
+	if args := flag.Args(); len(args) > 0 && (args[0] == "serve" || args[0] == "summarize") {
+		cmd = args[0]
+		// flags may also follow the command
+		flag.CommandLine.Parse(args[1:])
+	}
+
+	path := defaultMessagesFile
+	switch args := flag.Args(); len(args) {
+	case 0:
+	case 1:
+		path = args[0]
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	sigs := make(chan os.Signal, 1)
@@ -25,96 +82,219 @@ func main() {
 		cancel()
 	}()
 
+	if *histogram < 0 {
+		log.Fatal("-histogram-days can't be negative")
+	}
+	// summaries written out have no histogram, so it isn't worth keeping
+	histogramDays := *histogram
+	if cmd == "summarize" {
+		histogramDays = 0
+	}
+
 	// process stream and fetch summarized data
-	szData := processStream(ctx, "data/messages.1.data")
+	summary := processStream(ctx, path, histogramDays)
+
+	if cmd == "summarize" {
+		if err := writeSummary(os.Stdout, summary); err != nil {
+			log.Fatal("failed to write summary, err: ", err)
+		}
+		return
+	}
 
 	// create datastore
 	var ds serve.Datastore
 	var err error
 
-	if ds, err = datastore.CreateDatastore(szData.attributes, szData.events); err != nil {
+	if ds, err = datastore.CreateDatastore(summary); err != nil {
 		log.Fatal("failed to create data store, err: ", err)
 	}
 
 	// TODO: Can clear the summarized data to free up memory
 
 	// start the server
-	if err := serve.ListenAndServe(":1323", ds); err != nil {
+	if err := serve.ListenAndServe(*addr, ds); err != nil {
 		log.Fatal(err)
 	}
 }
 
-type summarizedData struct {
-	// attributes - map of user_id -> most recent Record with summarized attributes
-	attributes map[string]stream.Record
-	// events - user_id -> event_name -> count
-	events map[string]map[string]int
+// writeSummary - the identified users as customers, in the format of the verify files with their aggregates after the events, sorted by id
+func writeSummary(w io.Writer, summary *summarize.Summarizer) error {
+	customers := make([]*serve.Customer, 0, len(summary.Users))
+	for userID, user := range summary.Users {
+		id, err := strconv.Atoi(userID)
+		if err != nil || !user.Identified() {
+			continue
+		}
+		customers = append(customers, &serve.Customer{ID: id, Attributes: user.Attributes, Events: user.Events, Aggregates: user.Aggregates})
+	}
+	sort.Slice(customers, func(i, j int) bool { return customers[i].ID < customers[j].ID })
+
+	out := csv.NewWriter(bufio.NewWriter(w))
+	for _, customer := range customers {
+		if err := out.Write(serve.CSVRecord(customer)); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
 }
 
-func processStream(ctx context.Context, filepath string) summarizedData {
-	var totRecordsProcessed = 0
-	var start = time.Now()
-	var file *os.File
-	var err error
+// processStream - summarizes the messages of the file, - being stdin, keeping histograms of the last histogramDays
+func processStream(ctx context.Context, filepath string, histogramDays int) *summarize.Summarizer {
+	var s *stream.Stream
 
-	sd := summarizedData{
-		attributes: make(map[string]stream.Record),
-		events:     make(map[string]map[string]int),
+	sz := summarize.New()
+
+	f, err := messagesFormat(filepath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lateOpt, closeLate, err := latenessOption()
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := []stream.Option{stream.WithBufferSize(1024), stream.WithFormat(f)}
+	if lateOpt != nil {
+		opts = append(opts, lateOpt)
 	}
 
-	if file, err = os.Open(filepath); err != nil {
-		log.Fatal(fmt.Sprintf("failed to open file, error: %v", err))
+	cutoff, err := messagesCutoff()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cutoff != (summarize.Cutoff{}) {
+		opts = append(opts, stream.WithFilter(cutoff.Includes))
+	}
+	if *history {
+		sz.KeepHistory()
 	}
+	if sz.Aggregations, err = summarize.ParseAggregations(*aggregate); err != nil {
+		log.Fatal(err)
+	}
+	sz.HistogramDays = histogramDays
+
+	if filepath == "-" {
+		s, err = stream.Read(ctx, os.Stdin, opts...)
+	} else {
+		var file *os.File
+		if file, err = os.Open(filepath); err != nil {
+			log.Fatal(fmt.Sprintf("failed to open file, error: %v", err))
+		}
+		defer file.Close()
 
-	if ch, err := stream.Process(ctx, file); err == nil {
+		s, err = stream.NewStream(ctx, file, opts...)
+	}
+	if err != nil {
+		log.Fatal("stream processing failed: ", err)
+	}
 
-		// dupEvents hash to keep track of duplicate events - event_id -> bool
-		var dupEvents = make(map[string]bool)
+	for rec := range s.Records() {
+		sz.Apply(rec)
+	}
 
-		for rec := range ch {
-			totRecordsProcessed++
-			switch rec.Type {
-			case "event":
-				if _, prs := dupEvents[rec.ID]; prs {
-					continue
-				}
+	stats := s.Stats()
+	// a summary of part of the file would be served as if it were complete
+	if err := s.Err(); err != nil {
+		log.Fatal("stream processing failed after ", stats.Lines, " lines: ", err)
+	}
 
-				if _, prs := sd.events[rec.UserID]; !prs {
-					sd.events[rec.UserID] = make(map[string]int)
-				}
+	log.Println("time taken to process: ", stats.Elapsed)
+	log.Println("total records processed: ", stats.Records)
+	log.Printf("lines read: %d, undecodable: %d, %.0f lines/s, %.1f MB/s",
+		stats.Lines, stats.DecodeErrors, stats.LinesPerSecond(), stats.BytesPerSecond()/1e6)
+	if lateOpt != nil {
+		log.Printf("late records: %d (%s), watermark: %d", stats.Late, *late, stats.Watermark)
+	}
 
-				dupEvents[rec.ID] = true
-				sd.events[rec.UserID][rec.Name] += 1
+	if err := closeLate(); err != nil {
+		log.Fatal("failed to write late messages, err: ", err)
+	}
 
-			case "attributes":
-				// attributes are merged to prevent last-write-wins scenario
-				if xrecord, prs := sd.attributes[rec.UserID]; prs {
-					if rec.Timestamp >= xrecord.Timestamp {
-						// recent record so overwrite any common keys in `xrecord.Data`
-						rec.Data = utils.MergeMaps(rec.Data, xrecord.Data, true)
+	return sz
+}
 
-					} else {
-						// rec is old record, so keep `xrecord.Data & xrecord.Timestamp` but add any new keys in rec.Data
-						rec.Data = utils.MergeMaps(xrecord.Data, rec.Data, true)
-						rec.Timestamp = xrecord.Timestamp
-						rec.ID = xrecord.ID
-						rec.Position = xrecord.Position
-					}
-				}
+// messagesFormat - the format of the -format flag, or else of the extension of the file
+func messagesFormat(filepath string) (stream.Format, error) {
+	var f stream.Format
+	switch {
+	case *format != "":
+		var err error
+		if f, err = stream.FormatByName(*format); err != nil {
+			return nil, err
+		}
+	case filepath == "-":
+		f = &stream.NDJSON{}
+	default:
+		f = stream.FormatOf(filepath)
+	}
 
-				sd.attributes[rec.UserID] = *rec
-			}
+	if ndjson, ok := f.(*stream.NDJSON); ok {
+		ndjson.MaxLineSize = *maxLineSize
+	}
+	if *csvColumns != "" {
+		csv, ok := f.(*stream.CSV)
+		if !ok {
+			return nil, errors.New("-csv-columns needs CSV messages")
 		}
-		if err := ctx.Err(); err != nil {
-			log.Fatal(err)
+		var err error
+		if csv.Columns, err = stream.ParseCSVColumns(*csvColumns); err != nil {
+			return nil, err
 		}
-	} else {
-		log.Println("stream processing failed", err)
 	}
+	return f, nil
+}
+
+// latenessOption - the late policy of the flags, nil without -lateness. close flushes the side output of -late=divert
+func latenessOption() (opt stream.Option, close func() error, err error) {
+	policy, err := stream.ParseLatePolicy(*late)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *lateness <= 0 {
+		if policy != stream.LateAccept {
+			return nil, nil, fmt.Errorf("-late=%s needs a -lateness", policy)
+		}
+		return nil, func() error { return nil }, nil
+	}
+	if policy != stream.LateDivert {
+		return stream.WithLateness(*lateness, policy, nil), func() error { return nil }, nil
+	}
+
+	file, err := os.Create(*lateOutput)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
 
-	duration := time.Now().Sub(start)
-	log.Println("time taken to process: ", duration)
-	log.Println("total records processed: ", totRecordsProcessed)
+	// the first error is kept, the following messages are lost anyway
+	var werr error
+	side := func(rec *stream.Record) {
+		if werr == nil {
+			werr = enc.Encode(rec)
+		}
+	}
+	close = func() error {
+		if werr == nil {
+			werr = w.Flush()
+		}
+		if err := file.Close(); werr == nil {
+			werr = err
+		}
+		return werr
+	}
+	return stream.WithLateness(*lateness, policy, side), close, nil
+}
 
-	return sd
+// messagesCutoff - the point in time of the -as-of flags, the zero Cutoff keeps every message
+func messagesCutoff() (summarize.Cutoff, error) {
+	cutoff := summarize.Cutoff{Position: *asOfPos}
+	if *asOf != "" {
+		t, err := stream.ParseTimestamp(*asOf)
+		if err != nil {
+			return cutoff, fmt.Errorf("-as-of: %w", err)
+		}
+		cutoff.Timestamp = t.Unix()
+	}
+	return cutoff, nil
 }
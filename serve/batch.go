@@ -0,0 +1,156 @@
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+const maxBatchOperations = 1000
+
+// batch modes
+const (
+	batchAtomic  = "atomic"
+	batchPartial = "partial"
+)
+
+type batchResult struct {
+	Index    int       `json:"index"`
+	Status   int       `json:"status"`
+	Customer *Customer `json:"customer,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// errorResponse - the status code and message the single customer endpoints answer with for err
+func errorResponse(err error) (int, string) {
+	switch {
+	case IsNotFound(err):
+		return http.StatusNotFound, "customer not found"
+	case IsConflict(err):
+		return http.StatusConflict, err.Error()
+	case IsPreconditionFailed(err):
+		return http.StatusPreconditionFailed, "customer has been modified"
+	}
+	return http.StatusBadRequest, err.Error()
+}
+
+// Batch - runs create, update, replace and delete operations in a single datastore txn.
+// In the default atomic mode nothing is written unless every operation succeeds, in
+// partial mode the successful operations are kept and each result reports its own status.
+func (s server) Batch(c echo.Context) error {
+	request := struct {
+		Mode       string `json:"mode"`
+		Operations []struct {
+			Op         string             `json:"op"`
+			ID         int                `json:"id"`
+			Attributes map[string]*string `json:"attributes"`
+			Upsert     bool               `json:"upsert"`
+			Version    int                `json:"version"`
+		} `json:"operations"`
+	}{}
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	if request.Mode == "" {
+		request.Mode = batchAtomic
+	}
+	if request.Mode != batchAtomic && request.Mode != batchPartial {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("mode must be %q or %q", batchAtomic, batchPartial))
+	}
+	if len(request.Operations) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "operations are required")
+	}
+	if len(request.Operations) > maxBatchOperations {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d operations per batch", maxBatchOperations))
+	}
+
+	results := make([]batchResult, len(request.Operations))
+
+	// validate with the same rules as the single customer endpoints, only valid operations reach the datastore
+	var ops []BatchOperation
+	var indexes []int
+	invalid := false
+
+	for i, req := range request.Operations {
+		results[i].Index = i
+
+		op := BatchOperation{Op: req.Op, ID: req.ID, Upsert: req.Upsert, Version: req.Version}
+		attributes, removed := splitRemovals(req.Attributes)
+
+		var err error
+		switch req.Op {
+		case BatchCreate, BatchReplace:
+			if len(removed) > 0 {
+				err = errors.New("attributes can not be null")
+			} else if req.ID < 0 || (req.Op == BatchReplace && req.ID == 0) {
+				err = errors.New("id must be a positive number")
+			} else {
+				err = validateAttributes(attributes)
+			}
+			op.Attributes = attributes
+		case BatchUpdate:
+			err = validateChanges(attributes, removed)
+			op.Attributes, op.Removed = attributes, removed
+		case BatchDelete:
+		default:
+			err = fmt.Errorf("op must be one of %s, %s, %s or %s", BatchCreate, BatchUpdate, BatchReplace, BatchDelete)
+		}
+
+		if err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = err.Error()
+			invalid = true
+			continue
+		}
+
+		ops = append(ops, op)
+		indexes = append(indexes, i)
+	}
+
+	atomic := request.Mode == batchAtomic
+	failed := invalid
+
+	if !(atomic && invalid) {
+		outcomes, err := s.ds.Batch(ops, atomic)
+		if err != nil {
+			return err
+		}
+
+		for j, outcome := range outcomes {
+			result := &results[indexes[j]]
+			switch {
+			case outcome.Err != nil:
+				result.Status, result.Error = errorResponse(outcome.Err)
+				failed = true
+			case outcome.Created:
+				result.Status = http.StatusCreated
+			case ops[j].Op == BatchDelete:
+				result.Status = http.StatusNoContent
+			default:
+				result.Status = http.StatusOK
+			}
+			result.Customer = outcome.Customer
+		}
+	}
+
+	status := http.StatusOK
+	if atomic && failed {
+		// report why, everything else was rolled back or never ran
+		for i := range results {
+			if results[i].Error == "" {
+				results[i].Status = http.StatusFailedDependency
+				results[i].Error = "not applied, the batch failed"
+				results[i].Customer = nil
+			}
+		}
+		status = http.StatusUnprocessableEntity
+	}
+
+	return c.JSON(status, struct {
+		Mode    string        `json:"mode"`
+		Results []batchResult `json:"results"`
+	}{Mode: request.Mode, Results: results})
+}
@@ -0,0 +1,75 @@
+package serve_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	const operations = `[
+		{"op":"create","attributes":{"email":"new@example.com"}},
+		{"op":"update","id":1,"attributes":{"city":null,"plan":"pro"}},
+		{"op":"update","id":1,"attributes":{"email":null}},
+		{"op":"delete","id":9},
+		{"op":"replace","id":2,"attributes":{"email":"bill@example.com"}},
+		{"op":"create","id":1,"upsert":true,"attributes":{"email":"bill@example.com","plan":"pro"}}
+	]`
+
+	var tests = []struct {
+		mode     string
+		status   int
+		statuses []int
+		plan     string
+	}{
+		{"atomic", http.StatusUnprocessableEntity, []int{424, 424, 400, 424, 424, 424}, ""},
+		{"partial", http.StatusOK, []int{201, 200, 400, 404, 409, 200}, "pro"},
+	}
+
+	for _, tt := range tests {
+		h := newTestServer(t,
+			identify("1", map[string]string{"email": "bill@example.com", "city": "oslo"}),
+			identify("2", map[string]string{"email": "ann@example.com"}),
+		)
+
+		rec := request(h, http.MethodPost, "/customers/batch", `{"mode":"`+tt.mode+`","operations":`+operations+`}`)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.mode, rec.Code, tt.status, rec.Body)
+		}
+
+		var reply struct {
+			Results []struct {
+				Index  int    `json:"index"`
+				Status int    `json:"status"`
+				Error  string `json:"error"`
+			} `json:"results"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || len(reply.Results) != len(tt.statuses) {
+			t.Fatalf("%s: unexpected reply %s: %v", tt.mode, rec.Body, err)
+		}
+		for i, r := range reply.Results {
+			if r.Index != i || r.Status != tt.statuses[i] {
+				t.Errorf("%s: operation %d: %+v, want status %d", tt.mode, i, r, tt.statuses[i])
+			}
+		}
+
+		c := customerOf(t, request(h, http.MethodGet, "/customers/1", ""))
+		if c.Attributes["plan"] != tt.plan {
+			t.Errorf("%s: customer after the batch: %v", tt.mode, c.Attributes)
+		}
+		created := request(h, http.MethodGet, "/customers/3", "").Code == http.StatusOK
+		if created != (tt.mode == "partial") {
+			t.Errorf("%s: created customer stored: %v", tt.mode, created)
+		}
+	}
+
+	h := newTestServer(t)
+	for body, status := range map[string]int{
+		`{"mode":"some","operations":[{"op":"delete","id":1}]}`: http.StatusBadRequest,
+		`{"operations":[]}`: http.StatusBadRequest,
+	} {
+		if rec := request(h, http.MethodPost, "/customers/batch", body); rec.Code != status {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, status)
+		}
+	}
+}
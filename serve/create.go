@@ -1,21 +1,72 @@
 package serve
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/customerio/homework/stream"
 	"github.com/labstack/echo"
 )
 
-func isTimestamp(value string) bool {
-	num, err := strconv.Atoi(value)
+// the range of a valid created_at in Unix seconds, 1973 to 2286
+const (
+	minCreatedAt = 99999999
+	maxCreatedAt = 10000000000
+)
 
+// normalizeTimestamp - created_at as the Unix seconds it is stored as. Like the timestamps of messages it
+// can be sent in seconds, milliseconds, microseconds or as ISO 8601 (see stream.ParseTimestamp).
+func normalizeTimestamp(value string) (string, bool) {
+	t, err := stream.ParseTimestamp(value)
 	if err != nil {
-		return false
+		return "", false
+	}
+
+	sec := t.Unix()
+	if sec <= minCreatedAt || sec >= maxCreatedAt {
+		return "", false
+	}
+	return strconv.FormatInt(sec, 10), true
+}
+
+// validateAttributes - rules for a complete set of attributes (create and replace),
+// a missing or invalid created_at defaults to now
+func validateAttributes(attributes map[string]string) error {
+	if val, ok := attributes["email"]; !ok || val == "" {
+		return errors.New("email attribute is required")
 	}
 
-	return num > 99999999 && num < 10000000000
+	if createdAt, ok := normalizeTimestamp(attributes["created_at"]); ok {
+		attributes["created_at"] = createdAt
+	} else {
+		attributes["created_at"] = strconv.Itoa(int(time.Now().Unix()))
+	}
+	return nil
+}
+
+// validateChanges - rules for a partial update of the attributes
+func validateChanges(attributes map[string]string, removed []string) error {
+	for _, key := range removed {
+		if key == "email" || key == "created_at" {
+			return fmt.Errorf("%s attribute can not be removed", key)
+		}
+	}
+
+	if val, ok := attributes["email"]; ok && val == "" {
+		return errors.New("email attribute is required")
+	}
+
+	if val, ok := attributes["created_at"]; ok {
+		if createdAt, ok := normalizeTimestamp(val); ok {
+			attributes["created_at"] = createdAt
+		} else {
+			attributes["created_at"] = strconv.Itoa(int(time.Now().Unix()))
+		}
+	}
+	return nil
 }
 
 func (s server) Create(c echo.Context) error {
@@ -29,20 +80,39 @@ func (s server) Create(c echo.Context) error {
 		return err
 	}
 
-	if val, ok := request.Customer.Attributes["email"]; !ok || val == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "email attribute is required")
+	if request.Customer.ID < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "id must be a positive number")
 	}
 
-	if !isTimestamp(request.Customer.Attributes["created_at"]) {
-		request.Customer.Attributes["created_at"] = strconv.Itoa(int(time.Now().Unix()))
+	if request.Customer.Attributes == nil {
+		request.Customer.Attributes = make(map[string]string)
+	}
+	if err := validateAttributes(request.Customer.Attributes); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	customer, err := s.ds.Create(request.Customer.ID, request.Customer.Attributes)
+	// an existing customer is only overwritten when explicitly asked for
+	upsert, _ := strconv.ParseBool(c.QueryParam("upsert"))
+
+	// a missing id (0) is assigned by the datastore
+	customer, created, err := s.ds.Create(request.Customer.ID, request.Customer.Attributes, upsert)
 	if err != nil {
+		if IsConflict(err) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
 		return err
 	}
 
-	return c.JSON(http.StatusCreated, struct {
+	setETag(c, customer)
+
+	// an upsert of an existing customer is a replace, only new customers are created
+	status := http.StatusOK
+	if created {
+		c.Response().Header().Set(echo.HeaderLocation, "/customers/"+strconv.Itoa(customer.ID))
+		status = http.StatusCreated
+	}
+
+	return c.JSON(status, struct {
 		Customer *Customer `json:"customer"`
 	}{Customer: customer})
 }
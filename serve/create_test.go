@@ -0,0 +1,63 @@
+package serve_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+	h := newTestServer(t,
+		identify("1", map[string]string{"email": "bill@example.com"}),
+		identify("7", map[string]string{"email": "ann@example.com"}),
+	)
+
+	// ids are assigned above any id seen so far
+	for _, want := range []int{8, 9} {
+		rec := request(h, http.MethodPost, "/customers", `{"customer":{"attributes":{"email":"new`+strconv.Itoa(want)+`@example.com"}}}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if c := customerOf(t, rec); c.ID != want || c.Attributes["created_at"] == "" {
+			t.Errorf("customer %#v, want id %d and a created_at", c, want)
+		}
+		if location := rec.Header().Get("Location"); location != "/customers/"+strconv.Itoa(want) {
+			t.Errorf("Location %q, want /customers/%d", location, want)
+		}
+		if rec := request(h, http.MethodGet, rec.Header().Get("Location"), ""); rec.Code != http.StatusOK {
+			t.Errorf("created customer not found at its Location: %d", rec.Code)
+		}
+	}
+
+	var tests = []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{"explicit id", "/customers", `{"customer":{"id":20,"attributes":{"email":"a@example.com"}}}`, http.StatusCreated},
+		{"taken id", "/customers", `{"customer":{"id":20,"attributes":{"email":"b@example.com"}}}`, http.StatusConflict},
+		{"upsert", "/customers?upsert=true", `{"customer":{"id":20,"attributes":{"email":"b@example.com"}}}`, http.StatusOK},
+		{"negative id", "/customers", `{"customer":{"id":-1,"attributes":{"email":"c@example.com"}}}`, http.StatusBadRequest},
+		{"no email", "/customers", `{"customer":{"attributes":{"city":"oslo"}}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := request(h, http.MethodPost, tt.target, tt.body); rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	// an upsert only creates the customers that don't exist yet
+	rec := request(h, http.MethodPost, "/customers?upsert=true", `{"customer":{"id":30,"attributes":{"email":"e@example.com"}}}`)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/customers/30" {
+		t.Errorf("upsert of a new customer: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := request(h, http.MethodPost, "/customers?upsert=true", `{"customer":{"id":30,"attributes":{"email":"e@example.com"}}}`); rec.Header().Get("Location") != "" {
+		t.Errorf("upsert of an existing customer has a Location %q", rec.Header().Get("Location"))
+	}
+
+	// the sequence moved past the explicit id
+	if c := customerOf(t, request(h, http.MethodPost, "/customers", `{"customer":{"attributes":{"email":"d@example.com"}}}`)); c.ID != 31 {
+		t.Errorf("assigned id %d after an explicit 30, want 31", c.ID)
+	}
+}
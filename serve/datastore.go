@@ -1,13 +1,53 @@
 package serve
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
 
 var ErrNotFound = errors.New("not found")
 
+var ErrConflict = errors.New("conflict")
+
+// ErrPreconditionFailed - the customer's version isn't the one the write was conditioned on
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ErrNoHistory - the datastore doesn't keep the messages applied to customers
+var ErrNoHistory = errors.New("history is not retained")
+
+// ErrWindowTooLong - a segment condition counts the events of more days than the datastore keeps
+var ErrWindowTooLong = errors.New("within_days is longer than the days of events kept")
+
 func IsNotFound(err error) bool {
 	return errors.Is(err, ErrNotFound)
 }
 
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+func IsPreconditionFailed(err error) bool {
+	return errors.Is(err, ErrPreconditionFailed)
+}
+
+// ConflictError - returned when a write would give a customer a value that must be unique,
+// but is already owned by another customer
+type ConflictError struct {
+	Field string
+	Value string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("customer with %s %q already exists", e.Field, e.Value)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
 type Event struct {
 	Name  string
 	Count int
@@ -18,13 +58,167 @@ type Customer struct {
 	Attributes  map[string]string `json:"attributes"`
 	Events      map[string]int    `json:"events"`
 	LastUpdated int               `json:"last_updated"`
+	// Aggregates - aggregations of the numeric data of events, like "sum(purchase.price)", when configured
+	Aggregates map[string]float64 `json:"aggregates,omitempty"`
+	// Averaged - the number of values of the avg aggregates, see summarize.Summary
+	Averaged map[string]int `json:"-"`
+	// Histogram - daily event counts, served by GET /customers/:id/events/histogram
+	Histogram summarize.Histogram `json:"-"`
+	// Versions - of the attributes set by tracked messages, so later ones merge the same whatever their order.
+	// Attributes without one, like those edited through the API, have the version of LastUpdated.
+	Versions map[string]summarize.Version `json:"-"`
+	// Version is bumped on every write, used for optimistic concurrency
+	Version int `json:"version"`
+}
+
+// Clone - a deep copy of the customer. Customers handed out by a Datastore are shared
+// with concurrent readers, so writers must change a clone and store that instead.
+func (c *Customer) Clone() *Customer {
+	clone := *c
+
+	if c.Attributes != nil {
+		clone.Attributes = make(map[string]string, len(c.Attributes))
+		for k, v := range c.Attributes {
+			clone.Attributes[k] = v
+		}
+	}
+	if c.Events != nil {
+		clone.Events = make(map[string]int, len(c.Events))
+		for k, v := range c.Events {
+			clone.Events[k] = v
+		}
+	}
+	if c.Aggregates != nil {
+		clone.Aggregates = make(map[string]float64, len(c.Aggregates))
+		for k, v := range c.Aggregates {
+			clone.Aggregates[k] = v
+		}
+	}
+	if c.Averaged != nil {
+		clone.Averaged = make(map[string]int, len(c.Averaged))
+		for k, v := range c.Averaged {
+			clone.Averaged[k] = v
+		}
+	}
+	clone.Histogram = c.Histogram.Clone()
+	if c.Versions != nil {
+		clone.Versions = make(map[string]summarize.Version, len(c.Versions))
+		for k, v := range c.Versions {
+			clone.Versions[k] = v
+		}
+	}
+
+	return &clone
 }
 
+// Condition - a single rule of a segment, matched either against an attribute value
+// or against the count of an event, of the last WithinDays only if set
+type Condition struct {
+	Attribute  string `json:"attribute,omitempty"`
+	Event      string `json:"event,omitempty"`
+	Operator   string `json:"operator"`
+	Value      string `json:"value,omitempty"`
+	WithinDays int    `json:"within_days,omitempty"`
+}
+
+// Segment - a named set of conditions, a customer is a member when it satisfies all of them
+type Segment struct {
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	Conditions  []Condition `json:"conditions"`
+	LastUpdated int         `json:"last_updated"`
+}
+
+// batch operations
+const (
+	BatchCreate  = "create"
+	BatchUpdate  = "update"
+	BatchReplace = "replace"
+	BatchDelete  = "delete"
+)
+
+// BatchOperation - a single write of a batch, the fields mirror the arguments of the matching Datastore method
+type BatchOperation struct {
+	Op         string
+	ID         int
+	Attributes map[string]string
+	Removed    []string
+	Upsert     bool
+	Version    int
+}
+
+// BatchResult - the outcome of a single operation of a batch, Customer is nil for deletes and failures
+type BatchResult struct {
+	Customer *Customer
+	// Created - a create made a new customer, rather than upserting an existing one
+	Created bool
+	Err     error
+}
+
+// IngestResult - the outcome of a single tracked record. Customer is nil for duplicates and failures,
+// and for events of customers that haven't been identified yet: those are counted once they are.
+type IngestResult struct {
+	Customer  *Customer
+	Duplicate bool
+	Err       error
+}
+
+// CustomerFilter - narrows down the customers of Each, the zero value matches every customer
+type CustomerFilter struct {
+	// Segment only keeps the members of the segment
+	Segment int
+	// Query only keeps the customers Search would find for it
+	Query string
+}
+
+// Datastore - customers (and segments) returned by a Datastore must be treated as read only
 type Datastore interface {
 	List(page, count int) ([]*Customer, error)
 	Get(id int) (*Customer, error)
-	Create(id int, attributes map[string]string) (*Customer, error)
-	Update(id int, attributes map[string]string) (*Customer, error)
-	Delete(id int) error
+	// GetAsOf replays the messages applied to the customer up to asOf, in Unix seconds, to return the customer
+	// as they had made it then. It fails with ErrNoHistory unless the datastore retains the messages, and with
+	// ErrNotFound if the customer doesn't exist or wasn't identified yet. Edits made through the REST API aren't part of it.
+	GetAsOf(id int, asOf int64) (*Customer, error)
+	GetByEmail(email string) (*Customer, error)
+	// Create fails with a ConflictError if the id is taken, unless upsert is set.
+	// An id of 0 asks the datastore to assign one. created is false when an existing customer was upserted.
+	Create(id int, attributes map[string]string, upsert bool) (customer *Customer, created bool, err error)
+
+	// The writes below are compare-and-swap: when version isn't 0 they fail with
+	// ErrPreconditionFailed unless it is the current version of the customer.
+
+	// Update merges the attributes into the customer's and deletes the removed ones
+	Update(id int, attributes map[string]string, removed []string, version int) (*Customer, error)
+	// Patch applies the JSON Patch operations to the attributes of the customer atomically
+	Patch(id int, ops []PatchOperation, version int) (*Customer, error)
+	// Replace replaces all the attributes of the customer
+	Replace(id int, attributes map[string]string, version int) (*Customer, error)
+	Delete(id int, version int) error
+	// Batch runs all the operations in a single write txn and returns one result per operation.
+	// When atomic, nothing is written unless every operation succeeds; otherwise the successful
+	// operations are committed and the failed ones reported in their result.
+	Batch(ops []BatchOperation, atomic bool) ([]BatchResult, error)
+	// Import upserts complete customers, attributes and event counts, in a single write txn.
+	// It returns one error per customer, nil for the ones that were imported.
+	Import(customers []*Customer) ([]error, error)
+
+	// Ingest applies tracked records in a single write txn, with the rules used when loading the messages file:
+	// events are counted once per event id, and attributes are merged with the customer's, the most recent
+	// timestamp winning on common keys. It returns one result per record.
+	Ingest(records []*stream.Record) ([]IngestResult, error)
+
 	TotalCustomers() (int, error)
+	// Each calls fn for every customer matching the filter, in the order of List, from a single snapshot of the datastore.
+	// It stops at the first error returned by fn.
+	Each(filter CustomerFilter, fn func(*Customer) error) error
+	// Search returns a page of the customers matching the query, best matches first, along with the total number of matches
+	Search(query string, page, count int) ([]*Customer, int, error)
+
+	ListSegments() ([]*Segment, error)
+	GetSegment(id int) (*Segment, error)
+	CreateSegment(name string, conditions []Condition) (*Segment, error)
+	UpdateSegment(id int, name string, conditions []Condition) (*Segment, error)
+	DeleteSegment(id int) error
+	// SegmentCustomers returns a page of the segment members along with the total number of members
+	SegmentCustomers(id, page, count int) ([]*Customer, int, error)
 }
@@ -22,7 +22,15 @@ func (s server) Delete(c echo.Context) error {
 		return err
 	}
 
-	if err := s.ds.Delete(customer.ID); err != nil {
+	version, err := ifMatch(c, customer)
+	if err != nil {
+		return err
+	}
+
+	if err := s.ds.Delete(customer.ID, version); err != nil {
+		if IsPreconditionFailed(err) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "customer has been modified")
+		}
 		return err
 	}
 
@@ -0,0 +1,37 @@
+package serve
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// etag - a customer's ETag is its version, as a strong validator
+func etag(customer *Customer) string {
+	return `"` + strconv.Itoa(customer.Version) + `"`
+}
+
+func setETag(c echo.Context, customer *Customer) {
+	c.Response().Header().Set("ETag", etag(customer))
+}
+
+// ifMatch - the version a write must be conditioned on, given the customer as currently stored.
+// It returns 0 (unconditional) without an If-Match header and fails with 412 if none of the
+// listed ETags is the current one.
+func ifMatch(c echo.Context, current *Customer) (int, error) {
+	header := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		// weak ETags never match, If-Match uses the strong comparison
+		if strings.TrimSpace(tag) == etag(current) {
+			return current.Version, nil
+		}
+	}
+
+	return 0, echo.NewHTTPError(http.StatusPreconditionFailed, "customer has been modified")
+}
@@ -0,0 +1,105 @@
+package serve_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/customerio/homework/datastore"
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/summarize"
+)
+
+func TestETags(t *testing.T) {
+	writes := []struct {
+		method string
+		body   string
+		status int
+	}{
+		{http.MethodPatch, `{"customer":{"attributes":{"city":"rome"}}}`, http.StatusOK},
+		{http.MethodPatch, `[{"op":"replace","path":"/attributes/city","value":"rome"}]`, http.StatusOK},
+		{http.MethodPut, `{"customer":{"attributes":{"email":"bill@example.com"}}}`, http.StatusOK},
+		{http.MethodDelete, ``, http.StatusNoContent},
+	}
+
+	for _, w := range writes {
+		var header []string
+		if w.body != "" && w.body[0] == '[' {
+			header = []string{"Content-Type", "application/json-patch+json"}
+		}
+		name := w.method + " " + w.body
+
+		h := newTestServer(t, identify("1", map[string]string{"email": "bill@example.com", "city": "oslo"}))
+		rec := request(h, http.MethodGet, "/customers/1", "")
+		if rec.Header().Get("ETag") != `"1"` {
+			t.Fatalf("ETag of a new customer: %q", rec.Header().Get("ETag"))
+		}
+
+		for _, ifMatch := range []string{`"2"`, `W/"1"`, `"0", "3"`} {
+			if rec := request(h, w.method, "/customers/1", w.body, append(header, "If-Match", ifMatch)...); rec.Code != http.StatusPreconditionFailed {
+				t.Errorf("%s: If-Match %s: status %d, want 412", name, ifMatch, rec.Code)
+			}
+		}
+
+		rec = request(h, w.method, "/customers/1", w.body, append(header, "If-Match", `"7", "1"`)...)
+		if rec.Code != w.status {
+			t.Errorf("%s: matching If-Match: status %d, want %d: %s", name, rec.Code, w.status, rec.Body)
+			continue
+		}
+		if w.method == http.MethodDelete {
+			// a customer created with the same id carries on from the deleted one's version
+			rec := request(h, http.MethodPost, "/customers", `{"customer":{"id":1,"attributes":{"email":"ann@example.com"}}}`)
+			if rec.Code != http.StatusCreated || rec.Header().Get("ETag") != `"2"` {
+				t.Errorf("re-created customer: status %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+			}
+			if rec := request(h, http.MethodPatch, "/customers/1", `{"customer":{"attributes":{"city":"rome"}}}`, "If-Match", `"1"`); rec.Code != http.StatusPreconditionFailed {
+				t.Errorf("ETag of the deleted customer: status %d, want 412", rec.Code)
+			}
+			continue
+		}
+		if rec.Header().Get("ETag") != `"2"` {
+			t.Errorf("%s: ETag after the write: %q", name, rec.Header().Get("ETag"))
+		}
+
+		// the previous version is stale now, * and no header write unconditionally
+		if rec := request(h, w.method, "/customers/1", w.body, append(header, "If-Match", `"1"`)...); rec.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s: stale If-Match: status %d, want 412", name, rec.Code)
+		}
+		if rec := request(h, w.method, "/customers/1", w.body, append(header, "If-Match", `*`)...); rec.Code != w.status {
+			t.Errorf("%s: If-Match *: status %d", name, rec.Code)
+		}
+		if rec := request(h, w.method, "/customers/1", w.body, header...); rec.Code != w.status || rec.Header().Get("ETag") != `"4"` {
+			t.Errorf("%s: unconditional: status %d, ETag %q", name, rec.Code, rec.Header().Get("ETag"))
+		}
+	}
+}
+
+// racingDatastore - another write lands between the handler reading the customer and writing it
+type racingDatastore struct {
+	serve.Datastore
+}
+
+func (d racingDatastore) Get(id int) (*serve.Customer, error) {
+	customer, err := d.Datastore.Get(id)
+	if err == nil {
+		_, err = d.Datastore.Update(id, map[string]string{"plan": "pro"}, nil, 0)
+	}
+	return customer, err
+}
+
+// TestETagRace - the version is checked again by the datastore, in the write
+func TestETagRace(t *testing.T) {
+	for _, method := range []string{http.MethodPatch, http.MethodPut, http.MethodDelete} {
+		summary := summarize.New()
+		summary.Apply(identify("1", map[string]string{"email": "bill@example.com"}))
+		ds, err := datastore.CreateDatastore(summary)
+		if err != nil {
+			t.Fatalf("error creating datastore: %v", err)
+		}
+
+		h := serve.NewHandler(racingDatastore{ds})
+		rec := request(h, method, "/customers/1", `{"customer":{"attributes":{"email":"bill@example.com"}}}`, "If-Match", `"1"`)
+		if rec.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s: status %d, want 412: %s", method, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package serve
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// the response is flushed every exportFlushSize customers so clients can start reading right away
+const exportFlushSize = 1000
+
+// CSVRecord - a customer in the format of the verify files, read by the CSV import: attributes, events
+// then aggregates, each sorted by name
+func CSVRecord(customer *Customer) []string {
+	record := make([]string, 0, 1+len(customer.Attributes)+len(customer.Events)+len(customer.Aggregates))
+	record = append(record, strconv.Itoa(customer.ID))
+
+	attributes := make([]string, 0, len(customer.Attributes))
+	for name, value := range customer.Attributes {
+		attributes = append(attributes, name+"="+value)
+	}
+	sort.Strings(attributes)
+
+	events := make([]string, 0, len(customer.Events))
+	for name, count := range customer.Events {
+		events = append(events, name+"="+strconv.Itoa(count))
+	}
+	sort.Strings(events)
+
+	aggregates := make([]string, 0, len(customer.Aggregates))
+	for name, value := range customer.Aggregates {
+		aggregates = append(aggregates, name+"="+strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	sort.Strings(aggregates)
+
+	return append(append(append(record, attributes...), events...), aggregates...)
+}
+
+// Export - streams every customer as NDJSON (the default) or CSV straight from the datastore, optionally
+// only the members of a segment and/or the customers matching a search query.
+// Once streaming has started errors can't change the status anymore, they end the response early.
+func (s server) Export(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = importNDJSON
+	}
+	if format != importNDJSON && format != importCSV {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("format must be %q or %q", importNDJSON, importCSV))
+	}
+
+	filter := CustomerFilter{Query: strings.TrimSpace(c.QueryParam("q"))}
+	if segment := c.QueryParam("segment"); segment != "" {
+		id, err := strconv.Atoi(segment)
+		if err != nil || id <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "segment must be a segment id")
+		}
+		filter.Segment = id
+	}
+
+	res := c.Response()
+
+	var write func(*Customer) error
+	var flush func() error
+
+	switch format {
+	case importNDJSON:
+		res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+		enc := json.NewEncoder(res)
+		write = func(customer *Customer) error { return enc.Encode(customer) }
+		flush = func() error { return nil }
+	case importCSV:
+		res.Header().Set(echo.HeaderContentType, "text/csv; charset=UTF-8")
+		w := csv.NewWriter(res)
+		write = func(customer *Customer) error { return w.Write(CSVRecord(customer)) }
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	}
+
+	// the status is only sent with the first customer, so a missing segment is still a 404
+	start := func() {
+		if !res.Committed {
+			res.WriteHeader(http.StatusOK)
+		}
+	}
+
+	n := 0
+	err := s.ds.Each(filter, func(customer *Customer) error {
+		start()
+		if err := write(customer); err != nil {
+			return err
+		}
+
+		if n++; n%exportFlushSize == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			res.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if IsNotFound(err) && !res.Committed {
+			return echo.NewHTTPError(http.StatusNotFound, "segment not found")
+		}
+		return err
+	}
+
+	start()
+	return flush()
+}
@@ -0,0 +1,80 @@
+package serve_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
+
+func TestExport(t *testing.T) {
+	h := newTestServer(t,
+		identify("1", map[string]string{"email": "bill@example.com", "city": "oslo"}),
+		identify("2", map[string]string{"email": "ann@example.com", "city": "paris"}),
+		identify("3", map[string]string{"email": "zoe@example.com", "city": "oslo"}),
+		&stream.Record{ID: "e1", Type: summarize.TypeEvent, Name: "login", UserID: "1", Timestamp: 1560964022},
+	)
+
+	rec := request(h, http.MethodPost, "/segments", `{"segment":{"name":"oslo","conditions":[{"attribute":"city","operator":"eq","value":"oslo"}]}}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("segment: status %d: %s", rec.Code, rec.Body)
+	}
+
+	var tests = []struct {
+		name   string
+		target string
+		ids    []int
+	}{
+		{"all", "/customers/export", []int{1, 2, 3}},
+		{"segment", "/customers/export?segment=1", []int{1, 3}},
+		{"query", "/customers/export?q=ann", []int{2}},
+		{"segment and query", "/customers/export?segment=1&q=zoe", []int{3}},
+		{"empty segment", "/customers/export?segment=1&q=ann", []int{}},
+	}
+
+	for _, tt := range tests {
+		rec := request(h, http.MethodGet, tt.target, "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", tt.name, rec.Code, rec.Body)
+			continue
+		}
+
+		ids := []int{}
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			customer := &serve.Customer{}
+			if err := json.Unmarshal(scanner.Bytes(), customer); err != nil {
+				t.Fatalf("%s: invalid line %q: %v", tt.name, scanner.Text(), err)
+			}
+			ids = append(ids, customer.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: exported %v, want %v", tt.name, ids, tt.ids)
+		}
+	}
+
+	rec = request(h, http.MethodGet, "/customers/export?format=csv&segment=1", "")
+	if ctype := rec.Header().Get("Content-Type"); !strings.HasPrefix(ctype, "text/csv") {
+		t.Errorf("csv: content type %q", ctype)
+	}
+	want := "1,city=oslo,email=bill@example.com,login=1\n3,city=oslo,email=zoe@example.com\n"
+	if rec.Body.String() != want {
+		t.Errorf("csv: exported %q, want %q", rec.Body, want)
+	}
+
+	for target, status := range map[string]int{
+		"/customers/export?format=xml":   http.StatusBadRequest,
+		"/customers/export?segment=oslo": http.StatusBadRequest,
+		"/customers/export?segment=9":    http.StatusNotFound,
+	} {
+		if rec := request(h, http.MethodGet, target, ""); rec.Code != status {
+			t.Errorf("%s: status %d, want %d", target, rec.Code, status)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package serve
+
+import "time"
+
+// SetImportJobTTL - how long finished import jobs are kept, returns a func restoring the previous TTL
+func SetImportJobTTL(ttl time.Duration) func() {
+	previous := importJobTTL
+	importJobTTL = ttl
+	return func() { importJobTTL = previous }
+}
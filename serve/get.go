@@ -1,9 +1,12 @@
 package serve
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
 
+	"github.com/customerio/homework/stream"
 	"github.com/labstack/echo"
 )
 
@@ -14,6 +17,10 @@ func (s server) Get(c echo.Context) error {
 		return err
 	}
 
+	if asOf := c.QueryParam("as_of"); asOf != "" {
+		return s.getAsOf(c, id, asOf)
+	}
+
 	customer, err := s.ds.Get(id)
 	if err != nil {
 		if IsNotFound(err) {
@@ -26,5 +33,54 @@ func (s server) Get(c echo.Context) error {
 		Customer *Customer `json:"customer"`
 	}{customer}
 
+	setETag(c, customer)
+	return c.JSON(http.StatusOK, response)
+}
+
+// getAsOf - the customer as its messages had made it at the as_of timestamp, in any format of the messages.
+// It isn't a version of the stored customer, so it has no ETag.
+func (s server) getAsOf(c echo.Context, id int, asOf string) error {
+	t, err := stream.ParseTimestamp(asOf)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "as_of must be a unix timestamp or ISO 8601")
+	}
+
+	customer, err := s.ds.GetAsOf(id, t.Unix())
+	if err != nil {
+		switch {
+		case IsNotFound(err):
+			return echo.NewHTTPError(http.StatusNotFound, "customer not found at as_of")
+		case errors.Is(err, ErrNoHistory):
+			return echo.NewHTTPError(http.StatusNotImplemented, "as_of needs the history of messages, which the server doesn't keep")
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Customer *Customer `json:"customer"`
+		AsOf     int64     `json:"as_of"`
+	}{customer, t.Unix()})
+}
+
+func (s server) GetByEmail(c echo.Context) error {
+
+	email, err := url.PathUnescape(c.Param("email"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid email")
+	}
+
+	customer, err := s.ds.GetByEmail(email)
+	if err != nil {
+		if IsNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound, "customer not found")
+		}
+		return err
+	}
+
+	response := struct {
+		Customer *Customer `json:"customer"`
+	}{customer}
+
+	setETag(c, customer)
 	return c.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,78 @@
+package serve_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/customerio/homework/datastore"
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
+
+func TestGetAsOf(t *testing.T) {
+	summary := summarize.New()
+	summary.KeepHistory()
+	for _, rec := range []*stream.Record{
+		{ID: "e1", Type: summarize.TypeEvent, Name: "login", UserID: "1", Timestamp: 1560000000},
+		{ID: "a1", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "bill@example.com", "plan": "free"}, Timestamp: 1560000100},
+		{ID: "a2", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"plan": "pro"}, Timestamp: 1560000200},
+	} {
+		summary.Apply(rec)
+	}
+	ds, err := datastore.CreateDatastore(summary)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	var h http.Handler = serve.NewHandler(ds)
+
+	var tests = []struct {
+		asOf   string
+		status int
+		plan   string
+	}{
+		{"1560000150", http.StatusOK, "free"},
+		{"1560000150000", http.StatusOK, "free"},
+		{"2019-06-08T13:23:20Z", http.StatusOK, "pro"},
+		{"1560000200", http.StatusOK, "pro"},
+		// only its events have been seen
+		{"1560000050", http.StatusNotFound, ""},
+		{"yesterday", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		rec := request(h, http.MethodGet, "/customers/1?as_of="+tt.asOf, "")
+		if rec.Code != tt.status {
+			t.Errorf("as of %s: status %d, want %d: %s", tt.asOf, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		var reply struct {
+			Customer *serve.Customer `json:"customer"`
+			AsOf     int64           `json:"as_of"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || reply.Customer == nil {
+			t.Fatalf("as of %s: invalid reply %s: %v", tt.asOf, rec.Body, err)
+		}
+		if reply.Customer.Attributes["plan"] != tt.plan || reply.Customer.Events["login"] != 1 {
+			t.Errorf("as of %s: customer %+v, want the %s plan and 1 login", tt.asOf, reply.Customer, tt.plan)
+		}
+		if rec.Header().Get("ETag") != "" {
+			t.Errorf("as of %s: a past customer has an ETag", tt.asOf)
+		}
+	}
+
+	if rec := request(h, http.MethodGet, "/customers/2?as_of=1560000200", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown customer: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// without the history of messages
+	h = newTestServer(t, identify("1", map[string]string{"email": "bill@example.com"}))
+	if rec := request(h, http.MethodGet, "/customers/1?as_of=1560000200", ""); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without history: status %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+}
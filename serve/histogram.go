@@ -0,0 +1,43 @@
+package serve
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/customerio/homework/summarize"
+	"github.com/labstack/echo"
+)
+
+// EventHistogram - the event counts of the customer per day, week or month (?interval=, day by default),
+// of every event or of those of ?event=, for sparklines. Only the days the datastore retains are counted.
+func (s server) EventHistogram(c echo.Context) error {
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	interval := c.QueryParam("interval")
+	if interval == "" {
+		interval = summarize.IntervalDay
+	}
+
+	customer, err := s.ds.Get(id)
+	if err != nil {
+		if IsNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound, "customer not found")
+		}
+		return err
+	}
+
+	buckets, err := customer.Histogram.Buckets(interval, c.QueryParams()["event"]...)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		CustomerID int                `json:"customer_id"`
+		Interval   string             `json:"interval"`
+		Buckets    []summarize.Bucket `json:"buckets"`
+	}{customer.ID, interval, buckets})
+}
@@ -0,0 +1,98 @@
+package serve_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/customerio/homework/datastore"
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
+
+// monday - 2019-06-17, the start of a week
+const monday = 1560729600
+
+func TestEventHistogram(t *testing.T) {
+	summary := summarize.New()
+	summary.HistogramDays = 14
+	for _, rec := range []*stream.Record{
+		identify("1", map[string]string{"email": "bill@example.com"}),
+		identify("2", map[string]string{"email": "ann@example.com"}),
+		{ID: "e1", Type: summarize.TypeEvent, Name: "login", UserID: "1", Timestamp: monday + 3600},
+		{ID: "e2", Type: summarize.TypeEvent, Name: "login", UserID: "1", Timestamp: monday + 86400},
+		{ID: "e3", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Timestamp: monday + 86400 + 60},
+		{ID: "e4", Type: summarize.TypeEvent, Name: "login", UserID: "1", Timestamp: monday + 7*86400},
+	} {
+		summary.Apply(rec)
+	}
+	ds, err := datastore.CreateDatastore(summary)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	var h http.Handler = serve.NewHandler(ds)
+
+	empty := map[string]int{}
+	var tests = []struct {
+		name    string
+		target  string
+		status  int
+		buckets []summarize.Bucket
+	}{
+		{"days", "/customers/1/events/histogram", http.StatusOK, []summarize.Bucket{
+			{Start: monday, Events: map[string]int{"login": 1}},
+			{Start: monday + 86400, Events: map[string]int{"login": 1, "purchase": 1}},
+			{Start: monday + 2*86400, Events: empty},
+			{Start: monday + 3*86400, Events: empty},
+			{Start: monday + 4*86400, Events: empty},
+			{Start: monday + 5*86400, Events: empty},
+			{Start: monday + 6*86400, Events: empty},
+			{Start: monday + 7*86400, Events: map[string]int{"login": 1}},
+		}},
+		{"weeks", "/customers/1/events/histogram?interval=week", http.StatusOK, []summarize.Bucket{
+			{Start: monday, Events: map[string]int{"login": 2, "purchase": 1}},
+			{Start: monday + 7*86400, Events: map[string]int{"login": 1}},
+		}},
+		{"an event", "/customers/1/events/histogram?interval=week&event=purchase", http.StatusOK, []summarize.Bucket{
+			{Start: monday, Events: map[string]int{"purchase": 1}},
+		}},
+		{"an event never seen", "/customers/1/events/histogram?event=logout", http.StatusOK, []summarize.Bucket{}},
+		{"a customer without events", "/customers/2/events/histogram", http.StatusOK, []summarize.Bucket{}},
+		{"an unknown customer", "/customers/3/events/histogram", http.StatusNotFound, nil},
+		{"an unknown interval", "/customers/1/events/histogram?interval=hour", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		rec := request(h, http.MethodGet, tt.target, "")
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		var reply struct {
+			CustomerID int                `json:"customer_id"`
+			Buckets    []summarize.Bucket `json:"buckets"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || reply.Buckets == nil {
+			t.Fatalf("%s: invalid reply %s: %v", tt.name, rec.Body, err)
+		}
+		if !reflect.DeepEqual(reply.Buckets, tt.buckets) {
+			t.Errorf("%s: buckets %+v, want %+v", tt.name, reply.Buckets, tt.buckets)
+		}
+	}
+
+	// segments can't count the events of more days than the histograms keep
+	segment := `{"segment":{"name":"active","conditions":[{"event":"login","operator":"gte","value":"1","within_days":%s}]}}`
+	if rec := request(h, http.MethodPost, "/segments", fmt.Sprintf(segment, "15")); rec.Code != http.StatusBadRequest {
+		t.Errorf("a window longer than the histograms: status %d, want 400: %s", rec.Code, rec.Body)
+	}
+	if rec := request(h, http.MethodPost, "/segments", fmt.Sprintf(segment, "14")); rec.Code != http.StatusCreated {
+		t.Errorf("a window as long as the histograms: status %d, want 201: %s", rec.Code, rec.Body)
+	}
+}
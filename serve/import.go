@@ -0,0 +1,452 @@
+package serve
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/customerio/homework/summarize"
+	"github.com/labstack/echo"
+)
+
+// import formats
+const (
+	importNDJSON = "ndjson"
+	importCSV    = "csv"
+)
+
+const (
+	// customers are handed to the datastore in chunks, so the body is never held in memory as a whole
+	importChunkSize = 500
+	// a single customer line can be large, but not unbounded
+	maxImportLineSize = 1024 * 1024
+	// per line errors kept in a report, the totals keep counting past it
+	maxImportErrors = 100
+)
+
+// importJobTTL - how long the status and report of a finished import are kept
+var importJobTTL = time.Hour
+
+// import job states
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+type importLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type importReport struct {
+	Lines    int               `json:"lines"`
+	Imported int               `json:"imported"`
+	Failed   int               `json:"failed"`
+	Errors   []importLineError `json:"errors"`
+}
+
+// importer - streams customers from a reader into the datastore, the report can be read while it runs
+type importer struct {
+	ds Datastore
+
+	mu     sync.Mutex
+	report importReport
+}
+
+func (im *importer) fail(line int, err error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.report.Failed++
+	if len(im.report.Errors) < maxImportErrors {
+		im.report.Errors = append(im.report.Errors, importLineError{Line: line, Error: err.Error()})
+	}
+}
+
+func (im *importer) snapshot() importReport {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	report := im.report
+	report.Errors = append([]importLineError{}, im.report.Errors...)
+	return report
+}
+
+// run - imports every line of r, lines that can't be imported are reported and skipped.
+// The returned error is only for failures that stop the whole import.
+func (im *importer) run(r io.Reader, format string) error {
+	var next customerReader
+
+	switch format {
+	case importNDJSON:
+		next = ndjsonCustomers(r)
+	case importCSV:
+		next = csvCustomers(r)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	var chunk []*Customer
+	var lines []int
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+
+		errs, err := im.ds.Import(chunk)
+		if err != nil {
+			return err
+		}
+		for i, err := range errs {
+			if err != nil {
+				im.fail(lines[i], err)
+				continue
+			}
+			im.mu.Lock()
+			im.report.Imported++
+			im.mu.Unlock()
+		}
+
+		chunk, lines = chunk[:0], lines[:0]
+		return nil
+	}
+
+	for {
+		customer, line, err := next()
+		if err == io.EOF {
+			break
+		}
+
+		var lerr *lineError
+		if err != nil && !errors.As(err, &lerr) {
+			return err
+		}
+
+		im.mu.Lock()
+		im.report.Lines++
+		im.mu.Unlock()
+
+		if err == nil {
+			err = validateImport(customer)
+		}
+		if err != nil {
+			im.fail(line, err)
+			continue
+		}
+
+		chunk = append(chunk, customer)
+		lines = append(lines, line)
+		if len(chunk) == importChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
+
+// validateImport - the same rules as for Create, an imported customer also brings its events
+func validateImport(customer *Customer) error {
+	if customer.ID < 0 {
+		return errors.New("id must be a positive number")
+	}
+	for name, count := range customer.Events {
+		if count < 0 {
+			return fmt.Errorf("event %q has a negative count", name)
+		}
+	}
+	return validateAttributes(customer.Attributes)
+}
+
+// lineError - a single line that can't be decoded, the import carries on past it
+type lineError struct {
+	error
+}
+
+// customerReader - the next customer of an import and the line of the body it starts on, io.EOF at the end
+type customerReader func() (*Customer, int, error)
+
+// ndjsonCustomers - one customer per line, in the same JSON shape the API returns
+func ndjsonCustomers(r io.Reader) customerReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+	line := 0
+
+	return func() (*Customer, int, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, line + 1, err
+			}
+			return nil, line, io.EOF
+		}
+		line++
+
+		customer := &Customer{}
+		if err := json.Unmarshal(scanner.Bytes(), customer); err != nil {
+			return nil, line, &lineError{err}
+		}
+		if customer.Attributes == nil {
+			customer.Attributes = make(map[string]string)
+		}
+		if customer.Events == nil {
+			customer.Events = make(map[string]int)
+		}
+		return customer, line, nil
+	}
+}
+
+// csvCustomers - the format of the verification files written by generate:
+// `id,name=value,...` where numeric values are event counts, except for created_at and aggregates (see CSVRecord).
+// A quoted value can span lines, so lines are the ones records start on.
+func csvCustomers(r io.Reader) customerReader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	return func() (*Customer, int, error) {
+		record, err := reader.Read()
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				return nil, perr.StartLine, &lineError{err}
+			}
+			return nil, 0, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		customer := &Customer{
+			Attributes: make(map[string]string),
+			Events:     make(map[string]int),
+		}
+
+		if customer.ID, err = strconv.Atoi(record[0]); err != nil {
+			return nil, line, &lineError{fmt.Errorf("invalid id %q", record[0])}
+		}
+
+		for _, element := range record[1:] {
+			if element == "" {
+				continue
+			}
+
+			s := strings.SplitN(element, "=", 2)
+			if len(s) != 2 {
+				return nil, line, &lineError{fmt.Errorf("invalid element %q", element)}
+			}
+
+			if s[0] == "created_at" {
+				customer.Attributes[s[0]] = s[1]
+				continue
+			}
+
+			if summarize.IsAggregate(s[0]) {
+				value, err := strconv.ParseFloat(s[1], 64)
+				if err != nil {
+					return nil, line, &lineError{fmt.Errorf("invalid aggregate %q", element)}
+				}
+				if customer.Aggregates == nil {
+					customer.Aggregates = make(map[string]float64)
+				}
+				customer.Aggregates[s[0]] = value
+				continue
+			}
+
+			if count, err := strconv.Atoi(s[1]); err == nil {
+				customer.Events[s[0]] = count
+				continue
+			}
+
+			customer.Attributes[s[0]] = s[1]
+		}
+		return customer, line, nil
+	}
+}
+
+// importFormat - from the format query param, falling back to the content type
+func importFormat(c echo.Context) (string, error) {
+	if format := c.QueryParam("format"); format != "" {
+		if format != importNDJSON && format != importCSV {
+			return "", fmt.Errorf("format must be %q or %q", importNDJSON, importCSV)
+		}
+		return format, nil
+	}
+
+	ctype := c.Request().Header.Get(echo.HeaderContentType)
+	switch {
+	case strings.HasPrefix(ctype, "text/csv"):
+		return importCSV, nil
+	case strings.HasPrefix(ctype, "application/x-ndjson"), strings.HasPrefix(ctype, "application/jsonl"):
+		return importNDJSON, nil
+	}
+	return "", errors.New("set the format query param or a text/csv or application/x-ndjson content type")
+}
+
+type importJob struct {
+	ID string `json:"id"`
+
+	importer *importer
+	mu       sync.Mutex
+	status   string
+	err      error
+	// finished - when the import stopped, zero while it runs
+	finished time.Time
+}
+
+func (j *importJob) MarshalJSON() ([]byte, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	reply := struct {
+		ID     string       `json:"id"`
+		Status string       `json:"status"`
+		Error  string       `json:"error,omitempty"`
+		Report importReport `json:"report"`
+	}{ID: j.ID, Status: j.status, Report: j.importer.snapshot()}
+	if j.err != nil {
+		reply.Error = j.err.Error()
+	}
+	return json.Marshal(reply)
+}
+
+// importJobs - asynchronous imports, kept in memory until importJobTTL after they finish
+type importJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*importJob
+}
+
+func newImportJobs() *importJobs {
+	return &importJobs{jobs: make(map[string]*importJob)}
+}
+
+func (ij *importJobs) get(id string) *importJob {
+	ij.mu.Lock()
+	defer ij.mu.Unlock()
+
+	ij.evict(time.Now())
+	return ij.jobs[id]
+}
+
+// evict - forgets the jobs that finished more than importJobTTL ago, the caller must hold the lock.
+// Jobs are few and evicted as they are looked up or started, so there is no need for a timer.
+func (ij *importJobs) evict(now time.Time) {
+	for id, job := range ij.jobs {
+		job.mu.Lock()
+		expired := !job.finished.IsZero() && now.Sub(job.finished) > importJobTTL
+		job.mu.Unlock()
+
+		if expired {
+			delete(ij.jobs, id)
+		}
+	}
+}
+
+// start - runs the import of the file in the background, the file is removed once done
+func (ij *importJobs) start(im *importer, f *os.File, format string) (*importJob, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	job := &importJob{ID: hex.EncodeToString(buf), importer: im, status: jobRunning}
+
+	ij.mu.Lock()
+	ij.evict(time.Now())
+	ij.jobs[job.ID] = job
+	ij.mu.Unlock()
+
+	go func() {
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		err := im.run(f, format)
+		if err != nil {
+			log.Println("import job", job.ID, "failed:", err)
+		}
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		job.err = err
+		job.finished = time.Now()
+		if err != nil {
+			job.status = jobFailed
+		} else {
+			job.status = jobDone
+		}
+	}()
+
+	return job, nil
+}
+
+// Import - streams customers in NDJSON or CSV into the datastore, replacing existing customers.
+// With async=true the body is spooled to a temporary file and imported in the background,
+// the job can be followed at the Location of the 202 response.
+func (s server) Import(c echo.Context) error {
+	format, err := importFormat(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	im := &importer{ds: s.ds}
+
+	if async, _ := strconv.ParseBool(c.QueryParam("async")); !async {
+		if err := im.run(c.Request().Body, format); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, im.snapshot())
+	}
+
+	// the request body is gone once the handler returns
+	f, err := ioutil.TempFile("", "customers-import-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, c.Request().Body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	job, err := s.imports.start(im, f, format)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, "/customers/import/"+job.ID)
+	return c.JSON(http.StatusAccepted, struct {
+		Job *importJob `json:"job"`
+	}{Job: job})
+}
+
+// ImportJob - the status and report of an asynchronous import
+func (s server) ImportJob(c echo.Context) error {
+	job := s.imports.get(c.Param("id"))
+	if job == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "import job not found")
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Job *importJob `json:"job"`
+	}{Job: job})
+}
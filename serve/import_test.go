@@ -0,0 +1,166 @@
+package serve_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/customerio/homework/serve"
+)
+
+type importReport struct {
+	Lines    int `json:"lines"`
+	Imported int `json:"imported"`
+	Failed   int `json:"failed"`
+	Errors   []struct {
+		Line int `json:"line"`
+	} `json:"errors"`
+}
+
+// errorLines - the sorted lines of the errors of a report, datastore errors come after the decoding ones
+func (r importReport) errorLines() []int {
+	lines := []int{}
+	for _, e := range r.Errors {
+		lines = append(lines, e.Line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+type importJob struct {
+	ID     string       `json:"id"`
+	Status string       `json:"status"`
+	Report importReport `json:"report"`
+}
+
+func TestImport(t *testing.T) {
+	var tests = []struct {
+		name     string
+		format   string
+		body     string
+		imported int
+		failed   int
+		lines    []int
+	}{
+		{
+			name:   "ndjson",
+			format: "ndjson",
+			body: `{"id":5,"attributes":{"email":"ann@example.com"},"events":{"login":2},"aggregates":{"sum(buy.price)":12.5}}
+{"id":6,"attributes":{"email":"bill@example.com"}}
+not json
+{"id":7,"attributes":{"email":"ANN@example.com"}}
+{"id":-1}
+`,
+			imported: 2,
+			failed:   3,
+			lines:    []int{3, 4, 5},
+		},
+		{
+			// the quoted value spans lines 2 and 3, the errors are on the lines their records start on
+			name:   "csv",
+			format: "csv",
+			body: `5,email=ann@example.com,login=2,sum(buy.price)=12.5
+6,"email=bill@example.com,city=north
+pole",login=1
+x,email=zoe@example.com
+7,oops
+`,
+			imported: 2,
+			failed:   2,
+			lines:    []int{4, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		h := newTestServer(t)
+
+		rec := request(h, http.MethodPost, "/customers/import?format="+tt.format, tt.body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.name, rec.Code, rec.Body)
+		}
+
+		var report importReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: invalid report %s: %v", tt.name, rec.Body, err)
+		}
+		if report.Imported != tt.imported || report.Failed != tt.failed || report.Lines != tt.imported+tt.failed {
+			t.Errorf("%s: report %+v, want %d imported and %d failed", tt.name, report, tt.imported, tt.failed)
+		}
+		if lines := report.errorLines(); !reflect.DeepEqual(lines, tt.lines) {
+			t.Errorf("%s: errors on lines %v, want %v", tt.name, lines, tt.lines)
+		}
+
+		customer := customerOf(t, request(h, http.MethodGet, "/customers/5", ""))
+		if customer.Events["login"] != 2 || customer.Aggregates["sum(buy.price)"] != 12.5 {
+			t.Errorf("%s: imported customer %+v, want 2 logins and their aggregate", tt.name, customer)
+		}
+	}
+}
+
+func TestImportFormat(t *testing.T) {
+	h := newTestServer(t)
+
+	if rec := request(h, http.MethodPost, "/customers/import", `{"id":5}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("without a format: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := request(h, http.MethodPost, "/customers/import", "5,email=ann@example.com\n", "Content-Type", "text/csv"); rec.Code != http.StatusOK {
+		t.Errorf("with a text/csv content type: status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+func TestImportJob(t *testing.T) {
+	defer serve.SetImportJobTTL(50 * time.Millisecond)()
+
+	h := newTestServer(t)
+
+	rec := request(h, http.MethodPost, "/customers/import?format=ndjson&async=true", `{"id":5,"attributes":{"email":"ann@example.com"}}
+{"id":-5,"attributes":{"email":"bill@example.com"}}
+`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+
+	var reply struct {
+		Job importJob `json:"job"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("invalid reply %s: %v", rec.Body, err)
+	}
+	location := rec.Header().Get("Location")
+	if location != "/customers/import/"+reply.Job.ID {
+		t.Errorf("location %q for job %q", location, reply.Job.ID)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		rec = request(h, http.MethodGet, location, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("job status %d: %s", rec.Code, rec.Body)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+			t.Fatalf("invalid reply %s: %v", rec.Body, err)
+		}
+		if reply.Job.Status != "running" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still running: %s", rec.Body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if report := reply.Job.Report; reply.Job.Status != "done" || report.Imported != 1 || report.Failed != 1 {
+		t.Errorf("job %+v, want done with 1 imported and 1 failed", reply.Job)
+	}
+
+	// finished jobs are forgotten after the TTL
+	time.Sleep(100 * time.Millisecond)
+	if rec := request(h, http.MethodGet, location, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expired job: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := request(h, http.MethodGet, "/customers/import/unknown", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,103 @@
+package serve
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+	"github.com/labstack/echo"
+)
+
+// ingest statuses
+const (
+	ingestAccepted  = "accepted"
+	ingestDuplicate = "duplicate"
+	// an event of a customer that hasn't been identified yet, counted once it is
+	ingestPending = "pending"
+)
+
+// validateRecord - a record must belong to a customer, events need an id to be deduplicated.
+// A record without a timestamp happened now.
+func validateRecord(rec *stream.Record) error {
+	if id, err := strconv.Atoi(rec.UserID); err != nil || id <= 0 {
+		return errors.New("user_id must be a customer id")
+	}
+
+	switch rec.Type {
+	case summarize.TypeEvent:
+		if rec.ID == "" {
+			return errors.New("id is required")
+		}
+		if rec.Name == "" {
+			return errors.New("name is required")
+		}
+	case summarize.TypeAttributes:
+		if len(rec.Data) == 0 {
+			return errors.New("data is required")
+		}
+	default:
+		return errors.New("type must be " + summarize.TypeEvent + " or " + summarize.TypeAttributes)
+	}
+
+	if rec.Timestamp == 0 {
+		rec.Timestamp = time.Now().Unix()
+	}
+	return nil
+}
+
+// ingestStatus - how the record was applied
+func ingestStatus(result IngestResult) string {
+	switch {
+	case result.Duplicate:
+		return ingestDuplicate
+	case result.Customer == nil:
+		return ingestPending
+	}
+	return ingestAccepted
+}
+
+// Track - counts an event of a customer, events with an id seen before are ignored
+func (s server) Track(c echo.Context) error {
+	return s.ingest(c, summarize.TypeEvent)
+}
+
+// Identify - merges attributes into a customer's, creating it on its first attributes
+func (s server) Identify(c echo.Context) error {
+	return s.ingest(c, summarize.TypeAttributes)
+}
+
+func (s server) ingest(c echo.Context, recordType string) error {
+	rec := &stream.Record{}
+	if err := c.Bind(rec); err != nil {
+		return err
+	}
+
+	if rec.Type == "" {
+		rec.Type = recordType
+	}
+	if rec.Type != recordType {
+		return echo.NewHTTPError(http.StatusBadRequest, "type must be "+recordType)
+	}
+	if err := validateRecord(rec); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	results, err := s.ds.Ingest([]*stream.Record{rec})
+	if err != nil {
+		return err
+	}
+
+	result := results[0]
+	if result.Err != nil {
+		status, message := errorResponse(result.Err)
+		return echo.NewHTTPError(status, message)
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Status   string    `json:"status"`
+		Customer *Customer `json:"customer,omitempty"`
+	}{Status: ingestStatus(result), Customer: result.Customer})
+}
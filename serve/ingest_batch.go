@@ -0,0 +1,115 @@
+package serve
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/customerio/homework/stream"
+	"github.com/labstack/echo"
+)
+
+const (
+	// records are handed to the datastore in chunks, each one is a single write txn
+	ingestChunkSize = 500
+	// rejected records reported, the totals keep counting past it
+	maxIngestErrors = 100
+)
+
+type ingestError struct {
+	// Position - the offset in the body right after the record
+	Position int64  `json:"position"`
+	ID       string `json:"id,omitempty"`
+	Error    string `json:"error"`
+}
+
+type ingestReport struct {
+	Records   int           `json:"records"`
+	Accepted  int           `json:"accepted"`
+	Duplicate int           `json:"duplicate"`
+	Rejected  int           `json:"rejected"`
+	Errors    []ingestError `json:"errors"`
+	// Error - why reading the body stopped early
+	Error string `json:"error,omitempty"`
+}
+
+func (r *ingestReport) reject(rec *stream.Record, err error) {
+	r.Rejected++
+	if len(r.Errors) < maxIngestErrors {
+		r.Errors = append(r.Errors, ingestError{Position: rec.Position, ID: rec.ID, Error: err.Error()})
+	}
+}
+
+// IngestBatch - replays NDJSON messages, in the format of the messages files, into the datastore
+// with the same rules as /v1/track and /v1/identify, in order.
+// Lines that aren't valid JSON are skipped by the stream and counted as rejected.
+// The body is streamed, only a chunk of records is held in memory at a time.
+func (s server) IngestBatch(c echo.Context) error {
+	st, err := stream.Read(c.Request().Context(), c.Request().Body)
+	if err != nil {
+		return err
+	}
+	ch := st.Records()
+
+	report := ingestReport{Errors: []ingestError{}}
+	var chunk []*stream.Record
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+
+		results, err := s.ds.Ingest(chunk)
+		if err != nil {
+			return err
+		}
+		for i, result := range results {
+			switch {
+			case result.Err != nil:
+				_, message := errorResponse(result.Err)
+				report.reject(chunk[i], errors.New(message))
+			case result.Duplicate:
+				report.Duplicate++
+			default:
+				report.Accepted++
+			}
+		}
+
+		chunk = chunk[:0]
+		return nil
+	}
+
+	for rec := range ch {
+		report.Records++
+
+		if err := validateRecord(rec); err != nil {
+			report.reject(rec, err)
+			continue
+		}
+
+		chunk = append(chunk, rec)
+		if len(chunk) == ingestChunkSize {
+			if err := flush(); err != nil {
+				// drain the stream so its goroutine can exit
+				for range ch {
+				}
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	// lines the stream couldn't decode never made it to the channel
+	malformed := int(st.Stats().DecodeErrors)
+	report.Records += malformed
+	report.Rejected += malformed
+
+	// the records before the failure have been ingested, the report says how far it got
+	if err := st.Err(); err != nil {
+		report.Error = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, report)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
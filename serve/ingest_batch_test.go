@@ -0,0 +1,59 @@
+package serve_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIngestBatch(t *testing.T) {
+	h := newTestServer(t, identify("1", map[string]string{"email": "bill@example.com"}))
+
+	// more records than a chunk, so the body goes to the datastore in several txns
+	var body strings.Builder
+	for i := 0; i < 600; i++ {
+		fmt.Fprintf(&body, `{"id":"e%d","type":"event","name":"login","user_id":"1","timestamp":1560964022}`+"\n", i)
+	}
+	body.WriteString(`{"id":"e0","type":"event","name":"login","user_id":"1","timestamp":1560964022}` + "\n")
+	rejected := body.Len()
+	body.WriteString(`{"id":"x1","type":"event","name":"login","user_id":"bill","timestamp":1560964022}` + "\n")
+	body.WriteString("not json\n")
+	body.WriteString(`{"id":"a1","type":"attributes","user_id":"2","data":{"email":"ann@example.com"},"timestamp":1560964022}` + "\n")
+
+	rec := request(h, http.MethodPost, "/v1/batch", body.String())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var report struct {
+		Records   int `json:"records"`
+		Accepted  int `json:"accepted"`
+		Duplicate int `json:"duplicate"`
+		Rejected  int `json:"rejected"`
+		Errors    []struct {
+			Position int64  `json:"position"`
+			ID       string `json:"id"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report %s: %v", rec.Body, err)
+	}
+
+	if report.Records != 604 || report.Accepted != 601 || report.Duplicate != 1 || report.Rejected != 2 {
+		t.Errorf("report %+v, want 604 records with 601 accepted, 1 duplicate and 2 rejected", report)
+	}
+	// positions are right after the record, the undecodable line is only counted
+	want := int64(strings.Index(body.String()[rejected:], "\n") + rejected + 1)
+	if len(report.Errors) != 1 || report.Errors[0].ID != "x1" || report.Errors[0].Position != want {
+		t.Errorf("errors %+v, want x1 at %d", report.Errors, want)
+	}
+
+	if customer := customerOf(t, request(h, http.MethodGet, "/customers/1", "")); customer.Events["login"] != 600 {
+		t.Errorf("customer 1 has %d logins, want 600", customer.Events["login"])
+	}
+	if customer := customerOf(t, request(h, http.MethodGet, "/customers/2", "")); customer.Attributes["email"] != "ann@example.com" {
+		t.Errorf("customer 2 %+v, want created by its attributes", customer)
+	}
+}
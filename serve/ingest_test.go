@@ -0,0 +1,72 @@
+package serve_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/customerio/homework/serve"
+)
+
+func TestTrackIdentify(t *testing.T) {
+	h := newTestServer(t,
+		identify("1", map[string]string{"email": "bill@example.com"}),
+		identify("2", map[string]string{"email": "ann@example.com"}),
+	)
+
+	var tests = []struct {
+		name   string
+		target string
+		body   string
+		status int
+		// the status of an accepted request
+		ingest string
+	}{
+		{"event", "/v1/track", `{"id":"e1","name":"login","user_id":"1","timestamp":1560964022}`, http.StatusOK, "accepted"},
+		{"duplicate event id", "/v1/track", `{"id":"e1","name":"login","user_id":"1","timestamp":1560964022}`, http.StatusOK, "duplicate"},
+		{"event of an unidentified customer", "/v1/track", `{"id":"e2","name":"login","user_id":"3","timestamp":1560964022}`, http.StatusOK, "pending"},
+		{"attributes", "/v1/identify", `{"id":"a1","user_id":"1","data":{"plan":"pro"},"timestamp":1560964022}`, http.StatusOK, "accepted"},
+		{"attributes creating the customer", "/v1/identify", `{"id":"a2","user_id":"3","data":{"email":"zoe@example.com"},"timestamp":1560964022}`, http.StatusOK, "accepted"},
+		{"malformed body", "/v1/track", `{"id":"e3","name":`, http.StatusBadRequest, ""},
+		{"event without a name", "/v1/track", `{"id":"e3","user_id":"1"}`, http.StatusBadRequest, ""},
+		{"not a customer id", "/v1/track", `{"id":"e3","name":"login","user_id":"bill"}`, http.StatusBadRequest, ""},
+		{"attributes on track", "/v1/track", `{"type":"attributes","user_id":"1","data":{"plan":"pro"}}`, http.StatusBadRequest, ""},
+		{"malformed attributes", "/v1/identify", `{"user_id":"1","data":"pro"}`, http.StatusBadRequest, ""},
+		{"email of another customer", "/v1/identify", `{"id":"a3","user_id":"2","data":{"email":"BILL@example.com"}}`, http.StatusConflict, ""},
+	}
+
+	for _, tt := range tests {
+		rec := request(h, http.MethodPost, tt.target, tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		var reply struct {
+			Status   string          `json:"status"`
+			Customer *serve.Customer `json:"customer"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+			t.Fatalf("%s: invalid reply %s: %v", tt.name, rec.Body, err)
+		}
+		// only accepted records have a customer to show
+		if reply.Status != tt.ingest || (reply.Customer != nil) != (tt.ingest == "accepted") {
+			t.Errorf("%s: reply %s, want %s", tt.name, rec.Body, tt.ingest)
+		}
+	}
+
+	customer := customerOf(t, request(h, http.MethodGet, "/customers/1", ""))
+	if customer.Events["login"] != 1 || customer.Attributes["plan"] != "pro" {
+		t.Errorf("customer 1 %+v, want 1 login and the pro plan", customer)
+	}
+	// the held event is counted once the customer is identified
+	if customer := customerOf(t, request(h, http.MethodGet, "/customers/3", "")); customer.Events["login"] != 1 {
+		t.Errorf("customer 3 %+v, want its held login", customer)
+	}
+	if customer := customerOf(t, request(h, http.MethodGet, "/customers/2", "")); customer.Attributes["email"] != "ann@example.com" {
+		t.Errorf("customer 2 %+v, want its email kept", customer)
+	}
+}
@@ -9,15 +9,7 @@ import (
 
 func (s server) List(c echo.Context) error {
 
-	page := 1
-	perPage := 25
-
-	if val, err := strconv.Atoi(c.QueryParam("page")); err == nil && val > 0 {
-		page = val
-	}
-	if val, err := strconv.Atoi(c.QueryParam("per_page")); err == nil && val > 0 {
-		perPage = val
-	}
+	page, perPage := pagination(c)
 
 	total, err := s.ds.TotalCustomers()
 	if err != nil {
@@ -29,14 +21,35 @@ func (s server) List(c echo.Context) error {
 		return err
 	}
 
-	reply := struct {
-		Customers []*Customer `json:"customers"`
-		Meta      struct {
-			Page    int `json:"page"`
-			PerPage int `json:"per_page"`
-			Total   int `json:"total"`
-		} `json:"meta"`
-	}{}
+	return c.JSON(http.StatusOK, newListReply(customers, page, perPage, total))
+}
+
+// pagination - reads the page and per_page query params, falling back to the defaults
+func pagination(c echo.Context) (page, perPage int) {
+	page = 1
+	perPage = 25
+
+	if val, err := strconv.Atoi(c.QueryParam("page")); err == nil && val > 0 {
+		page = val
+	}
+	if val, err := strconv.Atoi(c.QueryParam("per_page")); err == nil && val > 0 {
+		perPage = val
+	}
+	return page, perPage
+}
+
+type listReply struct {
+	Customers []*Customer `json:"customers"`
+	Meta      struct {
+		Page    int `json:"page"`
+		PerPage int `json:"per_page"`
+		Total   int `json:"total"`
+	} `json:"meta"`
+}
+
+// newListReply - builds the paginated customers response shared by every listing endpoint
+func newListReply(customers []*Customer, page, perPage, total int) listReply {
+	reply := listReply{}
 
 	reply.Meta.Total = total
 
@@ -49,5 +62,5 @@ func (s server) List(c echo.Context) error {
 
 	reply.Customers = customers
 
-	return c.JSON(http.StatusOK, reply)
+	return reply
 }
@@ -0,0 +1,223 @@
+package serve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// MIMEJSONPatch - JSON Patch (RFC 6902)
+const MIMEJSONPatch = "application/json-patch+json"
+
+// attributesPointer - patches address single attributes, e.g. `/attributes/first_name`
+const attributesPointer = "/attributes/"
+
+// PatchOperation - a single JSON Patch operation on the attributes of a customer
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// PatchError - why a single operation of a patch can't be applied
+type PatchError struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// PatchErrors - all the operations of a patch that failed validation
+type PatchErrors []*PatchError
+
+func (pe PatchErrors) Error() string {
+	msgs := make([]string, 0, len(pe))
+	for _, e := range pe {
+		msgs = append(msgs, fmt.Sprintf("operation %d (%s %s): %s", e.Index, e.Op, e.Path, e.Message))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// attributeKey - the attribute addressed by a JSON pointer
+func attributeKey(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, attributesPointer) {
+		return "", fmt.Errorf("path must point to an attribute, e.g. %sname", attributesPointer)
+	}
+
+	key := strings.TrimPrefix(pointer, attributesPointer)
+	if key == "" || strings.Contains(key, "/") {
+		return "", fmt.Errorf("path must point to a single attribute")
+	}
+
+	// unescape per RFC 6901, ~1 first so that ~01 becomes ~1
+	return strings.Replace(strings.Replace(key, "~1", "/", -1), "~0", "~", -1), nil
+}
+
+// validateOperation - checks everything about an operation that doesn't depend on the customer
+func validateOperation(op *PatchOperation) (key, from, value string, err error) {
+	if key, err = attributeKey(op.Path); err != nil {
+		return
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			err = fmt.Errorf("value is required")
+			return
+		}
+		if jerr := json.Unmarshal(op.Value, &value); jerr != nil {
+			err = fmt.Errorf("value must be a string")
+			return
+		}
+	case "move", "copy":
+		if from, err = attributeKey(op.From); err != nil {
+			err = fmt.Errorf("from: %v", err)
+			return
+		}
+	case "remove":
+	default:
+		err = fmt.Errorf("unknown operation %q", op.Op)
+		return
+	}
+
+	// the same rules as for Create and Update
+	switch {
+	case op.Op == "remove" && (key == "email" || key == "created_at"):
+		err = fmt.Errorf("%s attribute can not be removed", key)
+	case op.Op == "move" && (from == "email" || from == "created_at"):
+		err = fmt.Errorf("%s attribute can not be removed", from)
+	case key == "email" && (op.Op == "add" || op.Op == "replace") && value == "":
+		err = fmt.Errorf("email attribute is required")
+	case key == "created_at" && (op.Op == "add" || op.Op == "replace"):
+		var ok bool
+		if value, ok = normalizeTimestamp(value); !ok {
+			err = fmt.Errorf("created_at must be a unix timestamp or ISO 8601")
+		}
+	}
+	return
+}
+
+// ValidatePatch - checks every operation of the patch, reporting all the invalid ones
+func ValidatePatch(ops []PatchOperation) error {
+	var errs PatchErrors
+	for i := range ops {
+		if _, _, _, err := validateOperation(&ops[i]); err != nil {
+			errs = append(errs, &PatchError{Index: i, Op: ops[i].Op, Path: ops[i].Path, Message: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ApplyPatch - applies the operations in order to a copy of the attributes, the patch is atomic
+// so the first operation that fails aborts it with a PatchErrors
+func ApplyPatch(attributes map[string]string, ops []PatchOperation) (map[string]string, error) {
+	if err := ValidatePatch(ops); err != nil {
+		return nil, err
+	}
+
+	patched := make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		patched[k] = v
+	}
+
+	for i := range ops {
+		op := &ops[i]
+		key, from, value, _ := validateOperation(op)
+
+		fail := func(format string, args ...interface{}) error {
+			return PatchErrors{{Index: i, Op: op.Op, Path: op.Path, Message: fmt.Sprintf(format, args...)}}
+		}
+
+		switch op.Op {
+		case "add":
+			patched[key] = value
+		case "replace":
+			if _, prs := patched[key]; !prs {
+				return nil, fail("attribute %q does not exist", key)
+			}
+			patched[key] = value
+		case "remove":
+			if _, prs := patched[key]; !prs {
+				return nil, fail("attribute %q does not exist", key)
+			}
+			delete(patched, key)
+		case "move", "copy":
+			fromValue, prs := patched[from]
+			if !prs {
+				return nil, fail("attribute %q does not exist", from)
+			}
+			if key == "created_at" {
+				var ok bool
+				if fromValue, ok = normalizeTimestamp(fromValue); !ok {
+					return nil, fail("attribute %q is not a valid %s", from, key)
+				}
+			}
+			if key == "email" && fromValue == "" {
+				return nil, fail("attribute %q is not a valid %s", from, key)
+			}
+			if op.Op == "move" {
+				delete(patched, from)
+			}
+			patched[key] = fromValue
+		case "test":
+			if current, prs := patched[key]; !prs || current != value {
+				return nil, fail("attribute %q is not %q", key, value)
+			}
+		}
+	}
+
+	return patched, nil
+}
+
+// patch - handles PATCH requests with a JSON Patch body
+func (s server) patch(c echo.Context, id, version int) error {
+	var ops []PatchOperation
+	if err := json.NewDecoder(c.Request().Body).Decode(&ops); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "body must be a JSON Patch array: "+err.Error())
+	}
+
+	if err := ValidatePatch(ops); err != nil {
+		return patchErrorResponse(c, err)
+	}
+
+	customer, err := s.ds.Patch(id, ops, version)
+	if err != nil {
+		if IsNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound, "customer not found")
+		}
+		if IsConflict(err) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		if IsPreconditionFailed(err) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "customer has been modified")
+		}
+		var pe PatchErrors
+		if errors.As(err, &pe) {
+			return patchErrorResponse(c, err)
+		}
+		return err
+	}
+
+	setETag(c, customer)
+	return c.JSON(http.StatusOK, struct {
+		Customer *Customer `json:"customer"`
+	}{Customer: customer})
+}
+
+func patchErrorResponse(c echo.Context, err error) error {
+	var pe PatchErrors
+	errors.As(err, &pe)
+
+	return c.JSON(http.StatusUnprocessableEntity, struct {
+		Message string      `json:"message"`
+		Errors  PatchErrors `json:"errors"`
+	}{Message: "patch can not be applied", Errors: pe})
+}
@@ -0,0 +1,148 @@
+package serve_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/customerio/homework/serve"
+)
+
+func TestApplyPatch(t *testing.T) {
+	var attributes = map[string]string{
+		"email":      "bill@example.com",
+		"created_at": "1560964022",
+		"city":       "toronto",
+		"ms":         "1560964023000",
+	}
+
+	var tests = []struct {
+		name    string
+		patch   string
+		want    map[string]string
+		failing []int
+	}{
+		{
+			name:  "add replace remove",
+			patch: `[{"op":"add","path":"/attributes/a~1b","value":"x"},{"op":"replace","path":"/attributes/city","value":"oslo"},{"op":"remove","path":"/attributes/a~1b"}]`,
+			want:  map[string]string{"email": "bill@example.com", "created_at": "1560964022", "city": "oslo", "ms": "1560964023000"},
+		},
+		{
+			name:  "move and test",
+			patch: `[{"op":"move","from":"/attributes/city","path":"/attributes/town"},{"op":"test","path":"/attributes/town","value":"toronto"}]`,
+			want:  map[string]string{"email": "bill@example.com", "created_at": "1560964022", "town": "toronto", "ms": "1560964023000"},
+		},
+		{
+			name:    "every invalid operation is reported",
+			patch:   `[{"op":"remove","path":"/attributes/email"},{"op":"add","path":"/attributes/ok","value":"1"},{"op":"add","path":"/events/x","value":"1"},{"op":"add","path":"/attributes/n","value":3}]`,
+			failing: []int{0, 2, 3},
+		},
+		{
+			name:  "created_at is normalized",
+			patch: `[{"op":"replace","path":"/attributes/created_at","value":"2019-06-19T17:07:02Z"},{"op":"copy","from":"/attributes/ms","path":"/attributes/created_at"}]`,
+			want:  map[string]string{"email": "bill@example.com", "created_at": "1560964023", "city": "toronto", "ms": "1560964023000"},
+		},
+		{
+			name:    "invalid created_at",
+			patch:   `[{"op":"replace","path":"/attributes/created_at","value":"yesterday"},{"op":"copy","from":"/attributes/city","path":"/attributes/created_at"}]`,
+			failing: []int{0},
+		},
+		{
+			name:    "failed test aborts the patch",
+			patch:   `[{"op":"add","path":"/attributes/ok","value":"1"},{"op":"test","path":"/attributes/city","value":"oslo"}]`,
+			failing: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		var ops []serve.PatchOperation
+		if err := json.Unmarshal([]byte(tt.patch), &ops); err != nil {
+			t.Fatalf("%s: error decoding patch: %v", tt.name, err)
+		}
+
+		have, err := serve.ApplyPatch(attributes, ops)
+
+		var pe serve.PatchErrors
+		if errors.As(err, &pe) {
+			var failing []int
+			for _, e := range pe {
+				failing = append(failing, e.Index)
+			}
+			if !reflect.DeepEqual(failing, tt.failing) {
+				t.Errorf("%s: failing operations\nwant: %v\nhave: %v", tt.name, tt.failing, failing)
+			}
+			continue
+		}
+		if err != nil || tt.failing != nil {
+			t.Errorf("%s: unexpected result, err: %v", tt.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%s: attributes don't match\nwant: %v\nhave: %v", tt.name, tt.want, have)
+		}
+	}
+
+	if attributes["city"] != "toronto" {
+		t.Errorf("ApplyPatch modified its input: %v", attributes)
+	}
+}
+
+func TestPatchRequest(t *testing.T) {
+	h := newTestServer(t, identify("1", map[string]string{"email": "bill@example.com", "city": "toronto"}))
+
+	var tests = []struct {
+		name   string
+		patch  string
+		status int
+		errors []*serve.PatchError
+	}{
+		{
+			name:   "bad path",
+			patch:  `[{"op":"add","path":"/attributes/plan","value":"pro"},{"op":"add","path":"/events/login","value":"1"}]`,
+			status: http.StatusUnprocessableEntity,
+			errors: []*serve.PatchError{{Index: 1, Op: "add", Path: "/events/login", Message: "path must point to an attribute, e.g. /attributes/name"}},
+		},
+		{
+			name:   "failed test",
+			patch:  `[{"op":"replace","path":"/attributes/city","value":"oslo"},{"op":"test","path":"/attributes/city","value":"toronto"}]`,
+			status: http.StatusUnprocessableEntity,
+			errors: []*serve.PatchError{{Index: 1, Op: "test", Path: "/attributes/city", Message: `attribute "city" is not "toronto"`}},
+		},
+		{
+			name:   "not an array",
+			patch:  `{"op":"remove","path":"/attributes/city"}`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		rec := request(h, http.MethodPatch, "/customers/1", tt.patch, "Content-Type", serve.MIMEJSONPatch)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.status != http.StatusUnprocessableEntity {
+			continue
+		}
+
+		var reply struct {
+			Message string              `json:"message"`
+			Errors  []*serve.PatchError `json:"errors"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || reply.Message == "" {
+			t.Fatalf("%s: invalid reply %s: %v", tt.name, rec.Body, err)
+		}
+		if !reflect.DeepEqual(reply.Errors, tt.errors) {
+			t.Errorf("%s: errors %s, want %+v", tt.name, rec.Body, tt.errors)
+		}
+	}
+
+	// no operation of a patch that can't be applied is
+	customer := customerOf(t, request(h, http.MethodGet, "/customers/1", ""))
+	if want := map[string]string{"email": "bill@example.com", "city": "toronto"}; !reflect.DeepEqual(customer.Attributes, want) {
+		t.Errorf("attributes %v, want %v", customer.Attributes, want)
+	}
+}
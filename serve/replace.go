@@ -0,0 +1,61 @@
+package serve
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+)
+
+// Replace - replaces all the attributes of a customer, attributes missing from the request are removed
+func (s server) Replace(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	customer, err := s.ds.Get(id)
+	if err != nil {
+		if IsNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound, "customer not found")
+		}
+		return err
+	}
+
+	version, err := ifMatch(c, customer)
+	if err != nil {
+		return err
+	}
+
+	request := struct {
+		Customer struct {
+			Attributes map[string]string `json:"attributes"`
+		} `json:"customer"`
+	}{}
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	if request.Customer.Attributes == nil {
+		request.Customer.Attributes = make(map[string]string)
+	}
+	if err := validateAttributes(request.Customer.Attributes); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	customer, err = s.ds.Replace(id, request.Customer.Attributes, version)
+	if err != nil {
+		if IsConflict(err) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		if IsPreconditionFailed(err) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "customer has been modified")
+		}
+		return err
+	}
+
+	setETag(c, customer)
+	return c.JSON(http.StatusOK, struct {
+		Customer *Customer `json:"customer"`
+	}{Customer: customer})
+}
@@ -0,0 +1,25 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+func (s server) Search(c echo.Context) error {
+
+	query := strings.TrimSpace(c.QueryParam("q"))
+	if query == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "q query param is required")
+	}
+
+	page, perPage := pagination(c)
+
+	customers, total, err := s.ds.Search(query, page, perPage)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, newListReply(customers, page, perPage, total))
+}
@@ -0,0 +1,168 @@
+package serve
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supported condition operators
+const (
+	OpEquals      = "eq"
+	OpNotEquals   = "neq"
+	OpContains    = "contains"
+	OpExists      = "exists"
+	OpGreater     = "gt"
+	OpGreaterOrEq = "gte"
+	OpLess        = "lt"
+	OpLessOrEq    = "lte"
+)
+
+// Validate - checks that the condition targets exactly one of attribute or event
+// and uses an operator that makes sense for it
+func (cd Condition) Validate() error {
+	if (cd.Attribute == "") == (cd.Event == "") {
+		return fmt.Errorf("condition must have either an attribute or an event")
+	}
+	if cd.WithinDays < 0 || (cd.WithinDays > 0 && cd.Event == "") {
+		return fmt.Errorf("within_days must be a number of days of an event condition")
+	}
+
+	switch cd.Operator {
+	case OpExists:
+		return nil
+	case OpEquals, OpNotEquals, OpGreater, OpGreaterOrEq, OpLess, OpLessOrEq:
+	case OpContains:
+		if cd.Event != "" {
+			return fmt.Errorf("operator %q is not supported for events", cd.Operator)
+		}
+	default:
+		return fmt.Errorf("unknown operator %q", cd.Operator)
+	}
+
+	if cd.Event != "" {
+		if _, err := strconv.Atoi(cd.Value); err != nil {
+			return fmt.Errorf("event condition value must be a count, got %q", cd.Value)
+		}
+	}
+	return nil
+}
+
+// Windowed - whether the condition depends on the time, a customer can stop matching it without being written
+func (cd Condition) Windowed() bool {
+	return cd.WithinDays > 0
+}
+
+// Matches - reports whether the customer satisfies the condition
+func (cd Condition) Matches(c *Customer) bool {
+	if cd.Event != "" {
+		count := c.Events[cd.Event]
+		if cd.Windowed() {
+			// today is the last of the days
+			since := time.Now().AddDate(0, 0, 1-cd.WithinDays).Unix()
+			count = c.Histogram.Count(cd.Event, since)
+		}
+		if cd.Operator == OpExists {
+			return count > 0
+		}
+		want, _ := strconv.Atoi(cd.Value)
+		return compareInts(cd.Operator, count, want)
+	}
+
+	value, prs := c.Attributes[cd.Attribute]
+	switch cd.Operator {
+	case OpExists:
+		return prs
+	case OpContains:
+		return prs && strings.Contains(strings.ToLower(value), strings.ToLower(cd.Value))
+	case OpNotEquals:
+		return value != cd.Value
+	}
+
+	if !prs {
+		return false
+	}
+
+	// numeric attributes (created_at, price etc.) are compared as numbers
+	if a, err := strconv.ParseFloat(value, 64); err == nil {
+		if b, err := strconv.ParseFloat(cd.Value, 64); err == nil {
+			return compareFloats(cd.Operator, a, b)
+		}
+	}
+	return compareStrings(cd.Operator, value, cd.Value)
+}
+
+// Validate - checks the name and every condition of the segment
+func (s *Segment) Validate() error {
+	if strings.TrimSpace(s.Name) == "" {
+		return fmt.Errorf("segment name is required")
+	}
+	if len(s.Conditions) == 0 {
+		return fmt.Errorf("segment must have at least one condition")
+	}
+	for i, cd := range s.Conditions {
+		if err := cd.Validate(); err != nil {
+			return fmt.Errorf("condition %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Windowed - whether any condition of the segment is, see Condition.Windowed
+func (s *Segment) Windowed() bool {
+	for _, cd := range s.Conditions {
+		if cd.Windowed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches - reports whether the customer satisfies all the conditions of the segment
+func (s *Segment) Matches(c *Customer) bool {
+	for _, cd := range s.Conditions {
+		if !cd.Matches(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareInts(op string, a, b int) bool {
+	return compareFloats(op, float64(a), float64(b))
+}
+
+func compareFloats(op string, a, b float64) bool {
+	switch op {
+	case OpEquals:
+		return a == b
+	case OpNotEquals:
+		return a != b
+	case OpGreater:
+		return a > b
+	case OpGreaterOrEq:
+		return a >= b
+	case OpLess:
+		return a < b
+	case OpLessOrEq:
+		return a <= b
+	}
+	return false
+}
+
+func compareStrings(op string, a, b string) bool {
+	switch op {
+	case OpEquals:
+		return a == b
+	case OpGreater:
+		return a > b
+	case OpGreaterOrEq:
+		return a >= b
+	case OpLess:
+		return a < b
+	case OpLessOrEq:
+		return a <= b
+	}
+	return false
+}
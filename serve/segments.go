@@ -0,0 +1,141 @@
+package serve
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+)
+
+type segmentRequest struct {
+	Segment struct {
+		Name       string      `json:"name"`
+		Conditions []Condition `json:"conditions"`
+	} `json:"segment"`
+}
+
+func (s server) ListSegments(c echo.Context) error {
+
+	segments, err := s.ds.ListSegments()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Segments []*Segment `json:"segments"`
+	}{Segments: segments})
+}
+
+func (s server) GetSegment(c echo.Context) error {
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	segment, err := s.ds.GetSegment(id)
+	if err != nil {
+		if IsNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound, "segment not found")
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Segment *Segment `json:"segment"`
+	}{Segment: segment})
+}
+
+func (s server) CreateSegment(c echo.Context) error {
+	request := segmentRequest{}
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	candidate := Segment{Name: request.Segment.Name, Conditions: request.Segment.Conditions}
+	if err := candidate.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	segment, err := s.ds.CreateSegment(candidate.Name, candidate.Conditions)
+	if err != nil {
+		if errors.Is(err, ErrWindowTooLong) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, struct {
+		Segment *Segment `json:"segment"`
+	}{Segment: segment})
+}
+
+func (s server) UpdateSegment(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	request := segmentRequest{}
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	candidate := Segment{Name: request.Segment.Name, Conditions: request.Segment.Conditions}
+	if err := candidate.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	segment, err := s.ds.UpdateSegment(id, candidate.Name, candidate.Conditions)
+	if err != nil {
+		switch {
+		case IsNotFound(err):
+			return echo.NewHTTPError(http.StatusNotFound, "segment not found")
+		case errors.Is(err, ErrWindowTooLong):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Segment *Segment `json:"segment"`
+	}{Segment: segment})
+}
+
+func (s server) DeleteSegment(c echo.Context) error {
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	if err := s.ds.DeleteSegment(id); err != nil {
+		if IsNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound, "segment not found")
+		}
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s server) SegmentCustomers(c echo.Context) error {
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	page, perPage := pagination(c)
+
+	customers, total, err := s.ds.SegmentCustomers(id, page, perPage)
+	if err != nil {
+		if IsNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound, "segment not found")
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, newListReply(customers, page, perPage, total))
+}
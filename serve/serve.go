@@ -12,30 +12,16 @@ import (
 )
 
 type server struct {
-	ds Datastore
+	ds      Datastore
+	imports *importJobs
 }
 
 func ListenAndServe(address string, datastore Datastore) error {
 
 	log.Println("listening on", address)
 
-	s := server{ds: datastore}
-
-	// Setup
-	e := echo.New()
-
-	// Middleware
-	e.Use(middleware.RequestID())
+	e := NewHandler(datastore)
 	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
-
-	// Routes
-	e.GET("/customers", s.List)
-	e.POST("/customers", s.Create)
-	e.GET("/customers/:id", s.Get)
-	e.PATCH("/customers/:id", s.Update)
-	e.DELETE("/customers/:id", s.Delete)
 
 	// Start server
 	go func() {
@@ -46,7 +32,7 @@ func ListenAndServe(address string, datastore Datastore) error {
 
 	// Wait for interrupt signal to gracefully shutdown the server with
 	// a timeout of 10 seconds.
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
 
@@ -55,3 +41,45 @@ func ListenAndServe(address string, datastore Datastore) error {
 
 	return e.Shutdown(ctx)
 }
+
+// NewHandler - the routes of the API served from the datastore, ListenAndServe adds request logging to it
+func NewHandler(datastore Datastore) *echo.Echo {
+
+	s := server{ds: datastore, imports: newImportJobs()}
+
+	// Setup
+	e := echo.New()
+
+	// Middleware
+	e.Use(middleware.RequestID())
+	e.Use(middleware.Recover())
+	e.Use(middleware.CORS())
+
+	// Routes
+	e.GET("/customers", s.List)
+	e.POST("/customers", s.Create)
+	e.POST("/customers/batch", s.Batch)
+	e.GET("/customers/export", s.Export)
+	e.POST("/customers/import", s.Import)
+	e.GET("/customers/import/:id", s.ImportJob)
+	e.GET("/customers/search", s.Search)
+	e.GET("/customers/by-email/:email", s.GetByEmail)
+	e.GET("/customers/:id", s.Get)
+	e.PATCH("/customers/:id", s.Update)
+	e.PUT("/customers/:id", s.Replace)
+	e.DELETE("/customers/:id", s.Delete)
+	e.GET("/customers/:id/events/histogram", s.EventHistogram)
+
+	e.GET("/segments", s.ListSegments)
+	e.POST("/segments", s.CreateSegment)
+	e.GET("/segments/:id", s.GetSegment)
+	e.PUT("/segments/:id", s.UpdateSegment)
+	e.DELETE("/segments/:id", s.DeleteSegment)
+	e.GET("/segments/:id/customers", s.SegmentCustomers)
+
+	e.POST("/v1/track", s.Track)
+	e.POST("/v1/identify", s.Identify)
+	e.POST("/v1/batch", s.IngestBatch)
+
+	return e
+}
@@ -0,0 +1,95 @@
+package serve_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/customerio/homework/datastore"
+	"github.com/customerio/homework/serve"
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
+
+// newTestServer - the API over a datastore loaded with the messages
+func newTestServer(t *testing.T, records ...*stream.Record) http.Handler {
+	t.Helper()
+
+	summary := summarize.New()
+	for _, rec := range records {
+		summary.Apply(rec)
+	}
+	ds, err := datastore.CreateDatastore(summary)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	return serve.NewHandler(ds)
+}
+
+// identify - an attributes message of the customer
+func identify(id string, attributes map[string]string) *stream.Record {
+	return &stream.Record{Type: summarize.TypeAttributes, UserID: id, Data: attributes, Timestamp: 1560964022}
+}
+
+// request - the response of the handler to a request with a JSON body and the given header name/value pairs
+func request(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// customerOf - the customer of a {"customer": ...} response
+func customerOf(t *testing.T, rec *httptest.ResponseRecorder) *serve.Customer {
+	t.Helper()
+
+	var reply struct {
+		Customer *serve.Customer `json:"customer"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || reply.Customer == nil {
+		t.Fatalf("no customer in response %d %s: %v", rec.Code, rec.Body, err)
+	}
+	return reply.Customer
+}
+
+func TestEmailConflicts(t *testing.T) {
+	h := newTestServer(t,
+		identify("1", map[string]string{"email": "bill@example.com"}),
+		identify("2", map[string]string{"email": "ann@example.com"}),
+	)
+
+	var tests = []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"create", http.MethodPost, "/customers", `{"customer":{"attributes":{"email":"BILL@example.com"}}}`, http.StatusConflict},
+		{"create with a free email", http.MethodPost, "/customers", `{"customer":{"attributes":{"email":"zoe@example.com"}}}`, http.StatusCreated},
+		{"update", http.MethodPatch, "/customers/2", `{"customer":{"attributes":{"email":"bill@example.com"}}}`, http.StatusConflict},
+		{"replace", http.MethodPut, "/customers/2", `{"customer":{"attributes":{"email":"bill@example.com"}}}`, http.StatusConflict},
+		{"update to its own email", http.MethodPatch, "/customers/1", `{"customer":{"attributes":{"email":"Bill@example.com"}}}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := request(h, tt.method, tt.target, tt.body); rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	if rec := request(h, http.MethodGet, "/customers/by-email/ANN%40example.com", ""); rec.Code != http.StatusOK || customerOf(t, rec).ID != 2 {
+		t.Errorf("customer not found by email: %d %s", rec.Code, rec.Body)
+	}
+	if rec := request(h, http.MethodGet, "/customers/by-email/nobody@example.com", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown email: %d %s", rec.Code, rec.Body)
+	}
+}
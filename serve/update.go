@@ -1,13 +1,19 @@
 package serve
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
-	"time"
+	"strings"
 
 	"github.com/labstack/echo"
 )
 
+// MIMEMergePatch - JSON Merge Patch (RFC 7396), the regular PATCH body already is a merge patch
+// of the customer resource so both content types are handled the same way
+const MIMEMergePatch = "application/merge-patch+json"
+
+// Update - merges the attributes of the request into the customer's, attributes set to null are removed
 func (s server) Update(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -22,29 +28,63 @@ func (s server) Update(c echo.Context) error {
 		return err
 	}
 
+	version, err := ifMatch(c, customer)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), MIMEJSONPatch) {
+		return s.patch(c, customer.ID, version)
+	}
+
+	// a nil value is a JSON null, i.e. a removal
 	request := struct {
 		Customer struct {
-			Attributes map[string]string `json:"attributes"`
+			Attributes map[string]*string `json:"attributes"`
 		} `json:"customer"`
 	}{}
-	if err := c.Bind(&request); err != nil {
-		return err
-	}
 
-	if val, ok := request.Customer.Attributes["email"]; !ok || val == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "email attribute is required")
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), MIMEMergePatch) {
+		if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	} else if err := c.Bind(&request); err != nil {
+		return err
 	}
 
-	if !isTimestamp(request.Customer.Attributes["created_at"]) {
-		request.Customer.Attributes["created_at"] = strconv.Itoa(int(time.Now().Unix()))
+	attributes, removed := splitRemovals(request.Customer.Attributes)
+	if err := validateChanges(attributes, removed); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	customer, err = s.ds.Update(id, request.Customer.Attributes)
+	customer, err = s.ds.Update(id, attributes, removed, version)
 	if err != nil {
+		if IsConflict(err) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		if IsPreconditionFailed(err) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "customer has been modified")
+		}
 		return err
 	}
 
+	setETag(c, customer)
 	return c.JSON(http.StatusOK, struct {
 		Customer *Customer `json:"customer"`
 	}{Customer: customer})
 }
+
+// splitRemovals - separates the attributes set to null (removed) from the ones being set
+func splitRemovals(changes map[string]*string) (map[string]string, []string) {
+	attributes := make(map[string]string)
+	var removed []string
+
+	for key, val := range changes {
+		if val == nil {
+			removed = append(removed, key)
+			continue
+		}
+		attributes[key] = *val
+	}
+	return attributes, removed
+}
@@ -0,0 +1,96 @@
+package serve_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	var tests = []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		want        map[string]string
+	}{
+		{
+			name:   "merge",
+			body:   `{"customer":{"attributes":{"city":"rome","zip":"00100"}}}`,
+			status: http.StatusOK,
+			want:   map[string]string{"email": "bill@example.com", "created_at": "1560964022", "city": "rome", "plan": "pro", "zip": "00100"},
+		},
+		{
+			name:   "null removes",
+			body:   `{"customer":{"attributes":{"city":null,"plan":"free"}}}`,
+			status: http.StatusOK,
+			want:   map[string]string{"email": "bill@example.com", "created_at": "1560964022", "plan": "free"},
+		},
+		{
+			name:        "merge patch content type",
+			contentType: "application/merge-patch+json",
+			body:        `{"customer":{"attributes":{"city":null}}}`,
+			status:      http.StatusOK,
+			want:        map[string]string{"email": "bill@example.com", "created_at": "1560964022", "plan": "pro"},
+		},
+		{
+			name:   "email can't be removed",
+			body:   `{"customer":{"attributes":{"email":null}}}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "created_at can't be removed",
+			body:   `{"customer":{"attributes":{"created_at":null}}}`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		h := newTestServer(t, identify("1", map[string]string{"email": "bill@example.com", "created_at": "1560964022", "city": "oslo", "plan": "pro"}))
+
+		var header []string
+		if tt.contentType != "" {
+			header = []string{"Content-Type", tt.contentType}
+		}
+		rec := request(h, http.MethodPatch, "/customers/1", tt.body, header...)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.want == nil {
+			continue
+		}
+		if c := customerOf(t, rec); !reflect.DeepEqual(c.Attributes, tt.want) || c.Version != 2 {
+			t.Errorf("%s: customer doesn't match\nwant: %v\nhave: %#v", tt.name, tt.want, c)
+		}
+	}
+
+	h := newTestServer(t)
+	if rec := request(h, http.MethodPatch, "/customers/1", `{"customer":{"attributes":{"city":"rome"}}}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown customer: status %d", rec.Code)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	h := newTestServer(t, identify("1", map[string]string{"email": "bill@example.com", "created_at": "1560964022", "city": "oslo"}))
+
+	// attributes missing from the request are removed
+	rec := request(h, http.MethodPut, "/customers/1", `{"customer":{"attributes":{"email":"bill@example.com","created_at":"2019-06-19T17:07:02Z","plan":"pro"}}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	want := map[string]string{"email": "bill@example.com", "created_at": "1560964022", "plan": "pro"}
+	if c := customerOf(t, rec); !reflect.DeepEqual(c.Attributes, want) {
+		t.Errorf("attributes don't match\nwant: %v\nhave: %v", want, c.Attributes)
+	}
+	if c := customerOf(t, request(h, http.MethodGet, "/customers/1", "")); !reflect.DeepEqual(c.Attributes, want) {
+		t.Errorf("replaced attributes not stored: %v", c.Attributes)
+	}
+
+	if rec := request(h, http.MethodPut, "/customers/1", `{"customer":{"attributes":{"plan":"free"}}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("replace without an email: status %d", rec.Code)
+	}
+	if rec := request(h, http.MethodPut, "/customers/2", `{"customer":{"attributes":{"email":"ann@example.com"}}}`); rec.Code != http.StatusNotFound {
+		t.Errorf("replace of an unknown customer: status %d", rec.Code)
+	}
+}
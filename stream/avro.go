@@ -0,0 +1,122 @@
+package stream
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// Avro - an Avro object container file of records with the fields of Record: id, type, name and
+// user_id as strings, timestamp as a long or a string (see ParseTimestamp) and data as a map of
+// strings. Any of them can be nullable unions, and fields the schema doesn't have are left empty.
+type Avro struct{}
+
+func (f *Avro) NewDecoder(r io.Reader) (RecordDecoder, error) {
+	reader, err := goavro.NewOCFReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading Avro header: %w", err)
+	}
+	return &avroDecoder{reader: reader}, nil
+}
+
+type avroDecoder struct {
+	reader *goavro.OCFReader
+	n      int64
+}
+
+func (d *avroDecoder) Decode(rec *Record) error {
+	if !d.reader.Scan() {
+		if err := d.reader.Err(); err != nil {
+			return fmt.Errorf("reading Avro record %d: %w", d.n+1, err)
+		}
+		return io.EOF
+	}
+
+	// a datum that can't be read leaves the block in an unknown state, that can't be skipped
+	datum, err := d.reader.Read()
+	if err != nil {
+		return fmt.Errorf("reading Avro record %d: %w", d.n+1, err)
+	}
+
+	d.n++
+	rec.Position = d.n
+	if err := avroRecord(datum, rec); err != nil {
+		return &DecodeError{Position: d.n, Err: err}
+	}
+	return nil
+}
+
+func avroRecord(datum interface{}, rec *Record) error {
+	fields, ok := datum.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%T is not a record", datum)
+	}
+
+	for _, field := range []struct {
+		name string
+		dst  *string
+	}{
+		{FieldID, &rec.ID},
+		{FieldType, &rec.Type},
+		{FieldName, &rec.Name},
+		{FieldUserID, &rec.UserID},
+	} {
+		switch value := avroValue(fields[field.name], "string").(type) {
+		case nil:
+		case string:
+			*field.dst = value
+		default:
+			return fmt.Errorf("%s is a %T, not a string", field.name, value)
+		}
+	}
+
+	switch value := avroValue(fields[FieldTimestamp], "long", "int", "string").(type) {
+	case nil:
+	case int64:
+		rec.Timestamp = UnixTime(value).Unix()
+	case int32:
+		rec.Timestamp = UnixTime(int64(value)).Unix()
+	case string:
+		t, err := ParseTimestamp(value)
+		if err != nil {
+			return err
+		}
+		rec.Timestamp = t.Unix()
+	default:
+		return fmt.Errorf("timestamp is a %T, not a long or a string", value)
+	}
+
+	switch value := avroValue(fields["data"], "map").(type) {
+	case nil:
+	case map[string]interface{}:
+		rec.Data = make(map[string]string, len(value))
+		for k, v := range value {
+			switch v := avroValue(v, "string").(type) {
+			case nil:
+			case string:
+				rec.Data[k] = v
+			default:
+				return fmt.Errorf("data %q is a %T, not a string", k, v)
+			}
+		}
+	default:
+		return fmt.Errorf("data is a %T, not a map", value)
+	}
+	return nil
+}
+
+// avroValue - the value of a union of one of the types, goavro decodes them to a map
+// from the name of the type to the value
+func avroValue(v interface{}, types ...string) interface{} {
+	union, ok := v.(map[string]interface{})
+	if !ok || len(union) != 1 {
+		return v
+	}
+	for _, name := range types {
+		if value, ok := union[name]; ok {
+			return value
+		}
+	}
+	return v
+}
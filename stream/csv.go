@@ -0,0 +1,185 @@
+package stream
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// record fields, the keys of CSV.Columns
+const (
+	FieldID        = "id"
+	FieldType      = "type"
+	FieldName      = "name"
+	FieldUserID    = "user_id"
+	FieldTimestamp = "timestamp"
+)
+
+// CSV - one message per row, with a header row naming the columns. Columns that aren't mapped to
+// a field of Record go to its Data, empty values are left out.
+type CSV struct {
+	// Columns - the header of the column of each field, by default the column named like the field
+	Columns map[string]string
+	// Comma - the field delimiter, ',' if 0
+	Comma rune
+}
+
+func (f *CSV) NewDecoder(r io.Reader) (RecordDecoder, error) {
+	reader := csv.NewReader(r)
+	if f.Comma != 0 {
+		reader.Comma = f.Comma
+	}
+	// rows are checked against the header below, with a better message
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return &csvDecoder{reader: reader}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	d := &csvDecoder{reader: reader, columns: len(header), fields: map[int]string{}, data: map[int]string{}}
+	byName := make(map[string]int, len(header))
+	for i, name := range header {
+		byName[name] = i
+	}
+
+	for field := range f.Columns {
+		if !isField(field) {
+			return nil, fmt.Errorf("unknown record field %q in CSV columns", field)
+		}
+	}
+	for _, field := range []string{FieldID, FieldType, FieldName, FieldUserID, FieldTimestamp} {
+		column, mapped := f.Columns[field]
+		if !mapped {
+			column = field
+		}
+		i, ok := byName[column]
+		if !ok {
+			// the default columns are optional, the ones asked for aren't
+			if mapped {
+				return nil, fmt.Errorf("CSV header has no column %q for %s", column, field)
+			}
+			continue
+		}
+		d.fields[i] = field
+	}
+
+	for i, name := range header {
+		if _, ok := d.fields[i]; !ok {
+			d.data[i] = name
+		}
+	}
+	return d, nil
+}
+
+// ParseCSVColumns - the CSV.Columns of a comma separated list of field=column, like user_id=uid,timestamp=ts
+func ParseCSVColumns(spec string) (map[string]string, error) {
+	columns := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("CSV column %q must be field=column", pair)
+		}
+		field, column := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if !isField(field) {
+			return nil, fmt.Errorf("unknown record field %q in CSV columns, must be one of %s, %s, %s, %s or %s",
+				field, FieldID, FieldType, FieldName, FieldUserID, FieldTimestamp)
+		}
+		if _, dup := columns[field]; dup {
+			return nil, fmt.Errorf("CSV column of %s given twice", field)
+		}
+		columns[field] = column
+	}
+	return columns, nil
+}
+
+func isField(field string) bool {
+	switch field {
+	case FieldID, FieldType, FieldName, FieldUserID, FieldTimestamp:
+		return true
+	}
+	return false
+}
+
+type csvDecoder struct {
+	reader  *csv.Reader
+	columns int
+	// fields - the Record field of the mapped columns, by index
+	fields map[int]string
+	// data - the Data key of the other columns, by index
+	data map[int]string
+	n    int64
+}
+
+func (d *csvDecoder) Decode(rec *Record) error {
+	if d.fields == nil {
+		return io.EOF
+	}
+
+	row, err := d.reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return err
+		}
+		// a row with a quote error can be skipped, the reader resumes with the next one
+		if _, ok := err.(*csv.ParseError); ok {
+			d.n++
+			return &DecodeError{Position: d.n, Err: err}
+		}
+		return fmt.Errorf("reading CSV record %d: %w", d.n+1, err)
+	}
+
+	d.n++
+	rec.Position = d.n
+	if len(row) != d.columns {
+		return &DecodeError{Position: d.n, Err: fmt.Errorf("%d columns, the header has %d", len(row), d.columns)}
+	}
+
+	for i, value := range row {
+		if field, ok := d.fields[i]; ok {
+			if err := setField(rec, field, value); err != nil {
+				return &DecodeError{Position: d.n, Err: err}
+			}
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		if rec.Data == nil {
+			rec.Data = map[string]string{}
+		}
+		rec.Data[d.data[i]] = value
+	}
+	return nil
+}
+
+func setField(rec *Record, field, value string) error {
+	switch field {
+	case FieldID:
+		rec.ID = value
+	case FieldType:
+		rec.Type = value
+	case FieldName:
+		rec.Name = value
+	case FieldUserID:
+		rec.UserID = value
+	case FieldTimestamp:
+		if value == "" {
+			return nil
+		}
+		t, err := ParseTimestamp(value)
+		if err != nil {
+			return err
+		}
+		rec.Timestamp = t.Unix()
+	}
+	return nil
+}
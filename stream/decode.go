@@ -0,0 +1,554 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxDepth - the deepest nesting of unknown fields, like encoding/json
+const maxDepth = 10000
+
+// maxInterned - the most distinct strings a fast decoder keeps, past it new ones are allocated every time
+const maxInterned = 4096
+
+// NewFastDecoder - a Decoder for the JSON of Record that doesn't go through reflection. It decodes the
+// same lines as JSONDecoder, fails on the same ones, and reuses its buffers and the strings that repeat
+// from line to line (record types, data keys) between calls, so it is not safe for concurrent use:
+// every stream needs its own. The default of NDJSON.
+func NewFastDecoder() Decoder {
+	d := &fastDecoder{interned: map[string]string{}}
+	return d.decode
+}
+
+type fastDecoder struct {
+	// scratch - unescaped strings are built in it
+	scratch  []byte
+	interned map[string]string
+
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (d *fastDecoder) decode(line []byte, rec *Record) error {
+	d.data, d.pos, d.depth = line, 0, 0
+	defer func() { d.data = nil }()
+
+	d.skipSpace()
+	if d.peek() == 'n' {
+		// null leaves rec as is, like for encoding/json
+		if err := d.literal("null"); err != nil {
+			return err
+		}
+	} else if err := d.record(rec); err != nil {
+		return err
+	}
+
+	d.skipSpace()
+	if d.pos < len(d.data) {
+		return d.syntaxError("after top-level value")
+	}
+	return nil
+}
+
+func (d *fastDecoder) record(rec *Record) error {
+	if err := d.expect('{'); err != nil {
+		return err
+	}
+
+	d.skipSpace()
+	if d.peek() == '}' {
+		d.pos++
+		return nil
+	}
+
+	// like encoding/json, a mismatched type doesn't stop the decoding, only the first one is returned
+	var typeErr error
+	for {
+		d.skipSpace()
+		key, err := d.rawString()
+		if err != nil {
+			return err
+		}
+		d.skipSpace()
+		if err := d.expect(':'); err != nil {
+			return err
+		}
+		d.skipSpace()
+
+		var fieldErr error
+		switch field := recordField(key); field {
+		case FieldID:
+			fieldErr = d.stringField(&rec.ID, field, false)
+		case FieldType:
+			fieldErr = d.stringField(&rec.Type, field, true)
+		case FieldName:
+			fieldErr = d.stringField(&rec.Name, field, false)
+		case FieldUserID:
+			fieldErr = d.stringField(&rec.UserID, field, false)
+		case FieldTimestamp:
+			fieldErr = d.timestamp(rec)
+		case "data":
+			fieldErr = d.dataField(rec)
+		default:
+			fieldErr = d.skipValue()
+		}
+		if fieldErr != nil {
+			var te *typeError
+			if !errors.As(fieldErr, &te) {
+				return fieldErr
+			}
+			if typeErr == nil {
+				typeErr = fieldErr
+			}
+		}
+
+		d.skipSpace()
+		switch d.peek() {
+		case ',':
+			d.pos++
+		case '}':
+			d.pos++
+			return typeErr
+		default:
+			return d.syntaxError("after object key:value pair")
+		}
+	}
+}
+
+// recordField - the field of Record the key is for, case insensitive like encoding/json
+func recordField(key []byte) string {
+	for _, field := range [...]string{FieldID, FieldType, FieldName, FieldUserID, FieldTimestamp, "data"} {
+		if string(key) == field {
+			return field
+		}
+	}
+	for _, field := range [...]string{FieldID, FieldType, FieldName, FieldUserID, FieldTimestamp, "data"} {
+		if bytes.EqualFold(key, []byte(field)) {
+			return field
+		}
+	}
+	return ""
+}
+
+// typeError - a valid JSON value of the wrong type for its field
+type typeError struct {
+	field string
+	value string
+}
+
+func (e *typeError) Error() string {
+	return fmt.Sprintf("cannot decode %s into field %s", e.value, e.field)
+}
+
+func (d *fastDecoder) stringField(dst *string, field string, intern bool) error {
+	switch d.peek() {
+	case '"':
+		b, err := d.rawString()
+		if err != nil {
+			return err
+		}
+		if intern {
+			*dst = d.intern(b)
+		} else {
+			*dst = string(b)
+		}
+		return nil
+	case 'n':
+		return d.literal("null")
+	}
+	return d.mismatch(field, "string")
+}
+
+// timestamp - normalized like Record.UnmarshalJSON does
+func (d *fastDecoder) timestamp(rec *Record) error {
+	var value []byte
+	switch c := d.peek(); {
+	case c == 'n':
+		return d.literal("null")
+	case c == '"':
+		s, err := d.rawString()
+		if err != nil {
+			return err
+		}
+		value = s
+	case c == '-' || c >= '0' && c <= '9':
+		number, err := d.number()
+		if err != nil {
+			return err
+		}
+		value = number
+	default:
+		return d.mismatch(FieldTimestamp, "number or string")
+	}
+
+	t, err := ParseTimestamp(string(value))
+	if err != nil {
+		return &typeError{field: FieldTimestamp, value: err.Error()}
+	}
+	rec.Timestamp = t.Unix()
+	return nil
+}
+
+func (d *fastDecoder) dataField(rec *Record) error {
+	switch d.peek() {
+	case 'n':
+		rec.Data = nil
+		return d.literal("null")
+	case '{':
+	default:
+		return d.mismatch("data", "object")
+	}
+	d.pos++
+
+	if rec.Data == nil {
+		rec.Data = map[string]string{}
+	}
+	d.skipSpace()
+	if d.peek() == '}' {
+		d.pos++
+		return nil
+	}
+
+	var typeErr error
+	for {
+		d.skipSpace()
+		key, err := d.rawString()
+		if err != nil {
+			return err
+		}
+		name := d.intern(key)
+		d.skipSpace()
+		if err := d.expect(':'); err != nil {
+			return err
+		}
+		d.skipSpace()
+
+		switch d.peek() {
+		case '"':
+			value, err := d.rawString()
+			if err != nil {
+				return err
+			}
+			rec.Data[name] = string(value)
+		case 'n':
+			// null and mismatched values are set to "", like encoding/json does
+			if err := d.literal("null"); err != nil {
+				return err
+			}
+			rec.Data[name] = ""
+		default:
+			err := d.mismatch("data."+name, "string")
+			var te *typeError
+			if !errors.As(err, &te) {
+				return err
+			}
+			rec.Data[name] = ""
+			if typeErr == nil {
+				typeErr = err
+			}
+		}
+
+		d.skipSpace()
+		switch d.peek() {
+		case ',':
+			d.pos++
+		case '}':
+			d.pos++
+			return typeErr
+		default:
+			return d.syntaxError("after object key:value pair")
+		}
+	}
+}
+
+// mismatch - skips the value at pos, returning a typeError if it is valid JSON
+func (d *fastDecoder) mismatch(field, want string) error {
+	start := d.pos
+	if err := d.skipValue(); err != nil {
+		return err
+	}
+	kind := map[byte]string{'{': "object", '[': "array", '"': "string", 't': "bool", 'f': "bool"}[d.data[start]]
+	if kind == "" {
+		kind = "number"
+	}
+	return &typeError{field: field, value: kind + ", expected " + want}
+}
+
+func (d *fastDecoder) intern(b []byte) string {
+	if s, ok := d.interned[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	if len(d.interned) < maxInterned {
+		d.interned[s] = s
+	}
+	return s
+}
+
+func (d *fastDecoder) peek() byte {
+	if d.pos < len(d.data) {
+		return d.data[d.pos]
+	}
+	return 0
+}
+
+func (d *fastDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\r', '\n':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (d *fastDecoder) expect(c byte) error {
+	if d.peek() != c {
+		return d.syntaxError(fmt.Sprintf("looking for %q", c))
+	}
+	d.pos++
+	return nil
+}
+
+func (d *fastDecoder) literal(lit string) error {
+	if !bytes.HasPrefix(d.data[d.pos:], []byte(lit)) {
+		return d.syntaxError("in literal " + lit)
+	}
+	d.pos += len(lit)
+	return nil
+}
+
+func (d *fastDecoder) syntaxError(context string) error {
+	if d.pos >= len(d.data) {
+		return errors.New("unexpected end of JSON input")
+	}
+	return fmt.Errorf("invalid character %q %s at offset %d", d.data[d.pos], context, d.pos)
+}
+
+// rawString - the string at pos, unescaped. Without escapes it is a slice of the line, otherwise
+// of the scratch buffer: either way it is only valid until the next call.
+func (d *fastDecoder) rawString() ([]byte, error) {
+	if err := d.expect('"'); err != nil {
+		return nil, err
+	}
+
+	start := d.pos
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		switch {
+		case c == '"':
+			s := d.data[start:d.pos]
+			d.pos++
+			return s, nil
+		case c == '\\' || c >= utf8.RuneSelf:
+			return d.unescape(start)
+		case c < ' ':
+			return nil, d.syntaxError("in string literal")
+		}
+		d.pos++
+	}
+	return nil, d.syntaxError("in string literal")
+}
+
+// unescape - the slow path of rawString, from the first escape or non ASCII character. Invalid UTF-8
+// is replaced with utf8.RuneError like encoding/json does.
+func (d *fastDecoder) unescape(start int) ([]byte, error) {
+	buf := append(d.scratch[:0], d.data[start:d.pos]...)
+	defer func() { d.scratch = buf[:0] }()
+
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		switch {
+		case c == '"':
+			d.pos++
+			return buf, nil
+		case c < ' ':
+			return nil, d.syntaxError("in string literal")
+		case c >= utf8.RuneSelf:
+			r, size := utf8.DecodeRune(d.data[d.pos:])
+			if r == utf8.RuneError && size == 1 {
+				buf = append(buf, string(utf8.RuneError)...)
+			} else {
+				buf = append(buf, d.data[d.pos:d.pos+size]...)
+			}
+			d.pos += size
+		case c != '\\':
+			buf = append(buf, c)
+			d.pos++
+		default:
+			d.pos++
+			switch d.peek() {
+			case '"', '\\', '/':
+				buf = append(buf, d.data[d.pos])
+			case 'b':
+				buf = append(buf, '\b')
+			case 'f':
+				buf = append(buf, '\f')
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'u':
+				r, ok := d.hex4(d.pos + 1)
+				if !ok {
+					return nil, d.syntaxError("in \\u hexadecimal character escape")
+				}
+				d.pos += 4
+				if utf16.IsSurrogate(r) {
+					r1 := r
+					r = utf8.RuneError
+					// the second half of the pair must follow right away
+					if d.pos+2 < len(d.data) && d.data[d.pos+1] == '\\' && d.data[d.pos+2] == 'u' {
+						if r2, ok := d.hex4(d.pos + 3); ok {
+							if dec := utf16.DecodeRune(r1, r2); dec != utf8.RuneError {
+								r = dec
+								d.pos += 6
+							}
+						}
+					}
+				}
+				buf = append(buf, string(r)...)
+			default:
+				return nil, d.syntaxError("in string escape code")
+			}
+			d.pos++
+		}
+	}
+	return nil, d.syntaxError("in string literal")
+}
+
+// hex4 - the 4 hex digits at i
+func (d *fastDecoder) hex4(i int) (rune, bool) {
+	if i+4 > len(d.data) {
+		return 0, false
+	}
+	var r rune
+	for _, c := range d.data[i : i+4] {
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r*16 + rune(c)
+	}
+	return r, true
+}
+
+// number - the JSON number at pos
+func (d *fastDecoder) number() ([]byte, error) {
+	start := d.pos
+	if d.peek() == '-' {
+		d.pos++
+	}
+	switch c := d.peek(); {
+	case c == '0':
+		d.pos++
+	case c >= '1' && c <= '9':
+		d.digits()
+	default:
+		return nil, d.syntaxError("in numeric literal")
+	}
+	if d.peek() == '.' {
+		d.pos++
+		if c := d.peek(); c < '0' || c > '9' {
+			return nil, d.syntaxError("after decimal point in numeric literal")
+		}
+		d.digits()
+	}
+	if c := d.peek(); c == 'e' || c == 'E' {
+		d.pos++
+		if c := d.peek(); c == '+' || c == '-' {
+			d.pos++
+		}
+		if c := d.peek(); c < '0' || c > '9' {
+			return nil, d.syntaxError("in exponent of numeric literal")
+		}
+		d.digits()
+	}
+	return d.data[start:d.pos], nil
+}
+
+func (d *fastDecoder) digits() {
+	for c := d.peek(); c >= '0' && c <= '9'; c = d.peek() {
+		d.pos++
+	}
+}
+
+// skipValue - skips the JSON value at pos, checking its syntax
+func (d *fastDecoder) skipValue() error {
+	switch c := d.peek(); {
+	case c == '"':
+		_, err := d.rawString()
+		return err
+	case c == 'n':
+		return d.literal("null")
+	case c == 't':
+		return d.literal("true")
+	case c == 'f':
+		return d.literal("false")
+	case c == '-' || c >= '0' && c <= '9':
+		_, err := d.number()
+		return err
+	case c == '{' || c == '[':
+		return d.skipComposite()
+	}
+	return d.syntaxError("looking for beginning of value")
+}
+
+func (d *fastDecoder) skipComposite() error {
+	if d.depth++; d.depth > maxDepth {
+		return errors.New("exceeded max depth")
+	}
+	defer func() { d.depth-- }()
+
+	end := byte('}')
+	if d.data[d.pos] == '[' {
+		end = ']'
+	}
+	d.pos++
+
+	d.skipSpace()
+	if d.peek() == end {
+		d.pos++
+		return nil
+	}
+	for {
+		d.skipSpace()
+		if end == '}' {
+			if _, err := d.rawString(); err != nil {
+				return err
+			}
+			d.skipSpace()
+			if err := d.expect(':'); err != nil {
+				return err
+			}
+			d.skipSpace()
+		}
+		if err := d.skipValue(); err != nil {
+			return err
+		}
+
+		d.skipSpace()
+		switch d.peek() {
+		case ',':
+			d.pos++
+		case end:
+			d.pos++
+			return nil
+		default:
+			return d.syntaxError("after value")
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package stream
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// RecordDecoder - reads the records of an input one by one
+type RecordDecoder interface {
+	// Decode decodes the next record into rec, returning io.EOF when there are none left.
+	// A *DecodeError means only that record is bad, the following ones can still be read.
+	// Decoders set rec.Position: the offset right after the record for line based formats,
+	// the number of the record in the input, starting at 1, for the others.
+	Decode(rec *Record) error
+}
+
+// Format - an encoding of messages files
+type Format interface {
+	NewDecoder(r io.Reader) (RecordDecoder, error)
+}
+
+// DecodeError - a single record that can't be decoded
+type DecodeError struct {
+	Position int64
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding record at %d: %v", e.Position, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// format names, as used by FormatByName
+const (
+	FormatNDJSON  = "ndjson"
+	FormatCSV     = "csv"
+	FormatAvro    = "avro"
+	FormatParquet = "parquet"
+)
+
+// FormatByName - the format with its default settings
+func FormatByName(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case FormatNDJSON, "json", "jsonl":
+		return &NDJSON{}, nil
+	case FormatCSV:
+		return &CSV{}, nil
+	case FormatAvro:
+		return &Avro{}, nil
+	case FormatParquet:
+		return &Parquet{}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, must be one of %s, %s, %s or %s", name, FormatNDJSON, FormatCSV, FormatAvro, FormatParquet)
+}
+
+// FormatOf - the format of a file from its extension, NDJSON for anything it doesn't know
+// like the .data files written by generate
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return &CSV{}
+	case ".avro":
+		return &Avro{}
+	case ".parquet":
+		return &Parquet{}
+	}
+	return &NDJSON{}
+}
+
+type readSeekerAt interface {
+	io.Reader
+	io.Seeker
+	io.ReaderAt
+}
+
+// countBytes - wraps r to count the bytes read from it in n. The wrapper keeps
+// the random access of r, if it has it, for formats like Parquet that need it.
+func countBytes(r io.Reader, n *int64) io.Reader {
+	c := &countingReader{r: r, n: n}
+	if rsa, ok := r.(readSeekerAt); ok {
+		return &countingReadSeekerAt{countingReader: c, rsa: rsa}
+	}
+	return c
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+type countingReadSeekerAt struct {
+	*countingReader
+	rsa readSeekerAt
+}
+
+func (c *countingReadSeekerAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.rsa.ReadAt(p, off)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+func (c *countingReadSeekerAt) Seek(offset int64, whence int) (int64, error) {
+	return c.rsa.Seek(offset, whence)
+}
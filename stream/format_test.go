@@ -0,0 +1,186 @@
+package stream_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/customerio/homework/stream"
+)
+
+var formatRecords = []*stream.Record{
+	{ID: "a1", Type: "attributes", UserID: "1", Data: map[string]string{"email": "a@example.com"}, Timestamp: 10, Position: 1},
+	{ID: "e1", Type: "event", Name: "signup", UserID: "1", Timestamp: 20, Position: 2},
+}
+
+// readAll - the records of a stream of input in format, failing on any error
+func readAll(t *testing.T, input io.Reader, format stream.Format) ([]*stream.Record, stream.Stats) {
+	t.Helper()
+
+	s, err := stream.Read(context.Background(), input, stream.WithFormat(format))
+	if err != nil {
+		t.Fatalf("error processing data: %v", err)
+	}
+
+	var records []*stream.Record
+	for rec := range s.Records() {
+		records = append(records, rec)
+	}
+	if s.Err() != nil {
+		t.Fatalf("unexpected error: %v", s.Err())
+	}
+	return records, s.Stats()
+}
+
+func checkRecords(t *testing.T, have, want []*stream.Record) {
+	t.Helper()
+
+	if len(have) != len(want) {
+		t.Fatalf("%d records, expected %d", len(have), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(have[i], want[i]) {
+			t.Errorf("record %d doesn't match\nwant: %+v\nhave: %+v", i, want[i], have[i])
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	input := `event_id,kind,event,customer,ts,email
+a1,attributes,,1,10,a@example.com
+e1,event,signup,1,20,
+e2,event,signup,1,not a number,
+`
+	format := &stream.CSV{Columns: map[string]string{
+		stream.FieldID:        "event_id",
+		stream.FieldType:      "kind",
+		stream.FieldName:      "event",
+		stream.FieldUserID:    "customer",
+		stream.FieldTimestamp: "ts",
+	}}
+
+	records, stats := readAll(t, strings.NewReader(input), format)
+	checkRecords(t, records, formatRecords)
+	if stats.Lines != 3 || stats.DecodeErrors != 1 || stats.Bytes != int64(len(input)) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// the default mapping is by field name
+	input = "id,type,user_id,plan\nx,attributes,2,pro\n"
+	records, _ = readAll(t, strings.NewReader(input), &stream.CSV{})
+	checkRecords(t, records, []*stream.Record{
+		{ID: "x", Type: "attributes", UserID: "2", Data: map[string]string{"plan": "pro"}, Position: 1},
+	})
+
+	format = &stream.CSV{Columns: map[string]string{stream.FieldUserID: "customer"}}
+	if _, err := format.NewDecoder(strings.NewReader(input)); err == nil {
+		t.Errorf("a missing mapped column should fail")
+	}
+}
+
+func TestParseCSVColumns(t *testing.T) {
+	columns, err := stream.ParseCSVColumns("id=event_id, type=kind,name=event,user_id=customer,timestamp=ts,")
+	want := map[string]string{
+		stream.FieldID:        "event_id",
+		stream.FieldType:      "kind",
+		stream.FieldName:      "event",
+		stream.FieldUserID:    "customer",
+		stream.FieldTimestamp: "ts",
+	}
+	if err != nil || !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns %v, %v\nwant %v", columns, err, want)
+	}
+
+	for _, spec := range []string{"user_id", "user_id=", "email=mail", "user_id=a,user_id=b"} {
+		if columns, err := stream.ParseCSVColumns(spec); err == nil {
+			t.Errorf("%q: columns %v, want an error", spec, columns)
+		}
+	}
+}
+
+func TestAvro(t *testing.T) {
+	var input bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W: &input,
+		Schema: `{"type": "record", "name": "message", "fields": [
+			{"name": "id", "type": "string"},
+			{"name": "type", "type": "string"},
+			{"name": "name", "type": ["null", "string"]},
+			{"name": "user_id", "type": "string"},
+			{"name": "data", "type": ["null", {"type": "map", "values": "string"}]},
+			{"name": "timestamp", "type": "long"}
+		]}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Append([]interface{}{
+		map[string]interface{}{
+			"id": "a1", "type": "attributes", "name": nil, "user_id": "1", "timestamp": int64(10),
+			"data": goavro.Union("map", map[string]interface{}{"email": "a@example.com"}),
+		},
+		map[string]interface{}{
+			"id": "e1", "type": "event", "name": goavro.Union("string", "signup"), "user_id": "1", "timestamp": int64(20),
+			"data": nil,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, _ := readAll(t, &input, &stream.Avro{})
+	checkRecords(t, records, formatRecords)
+}
+
+func TestParquet(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(n int64) *int64 { return &n }
+
+	var input bytes.Buffer
+	w, err := writer.NewParquetWriterFromWriter(&input, new(stream.ParquetRecord), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []stream.ParquetRecord{
+		{ID: str("a1"), Type: str("attributes"), UserID: str("1"), Timestamp: num(10), Data: map[string]string{"email": "a@example.com"}},
+		{ID: str("e1"), Type: str("event"), Name: str("signup"), UserID: str("1"), Timestamp: num(20)},
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a pipe can't seek, the decoder reads it in memory
+	records, _ := readAll(t, bytes.NewBuffer(input.Bytes()), &stream.Parquet{BatchSize: 1})
+	checkRecords(t, records, formatRecords)
+
+	records, _ = readAll(t, bytes.NewReader(input.Bytes()), &stream.Parquet{})
+	checkRecords(t, records, formatRecords)
+}
+
+func TestFormatOf(t *testing.T) {
+	for path, want := range map[string]stream.Format{
+		"data/messages.1.data": &stream.NDJSON{},
+		"messages.csv":         &stream.CSV{},
+		"messages.AVRO":        &stream.Avro{},
+		"messages.parquet":     &stream.Parquet{},
+	} {
+		if have := stream.FormatOf(path); reflect.TypeOf(have) != reflect.TypeOf(want) {
+			t.Errorf("%s: %T, expected %T", path, have, want)
+		}
+	}
+
+	if _, err := stream.FormatByName("xml"); err == nil {
+		t.Errorf("unknown formats should fail")
+	}
+}
@@ -1,12 +1,14 @@
 package stream
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Record struct {
@@ -15,50 +17,178 @@ type Record struct {
 	Name      string            `json:"name"`
 	UserID    string            `json:"user_id"`
 	Data      map[string]string `json:"data"`
-	Timestamp int64             `json:"timestamp"`
+	Timestamp int64             `json:"timestamp"` // Unix seconds, whatever it was sent as (see ParseTimestamp)
 
 	// Position in the input stream where this record lives.
 	Position int64 `json:"-"`
 }
 
-// Process returns a channel to which a stream of records are sent. Reading starts at
-// the current seek offset in the file. The channel is closed when no more records are available.
+// Stream - records read from a file, sent on the channel returned by Records
+type Stream struct {
+	// first for the alignment of its 64 bit atomics
+	counters counters
+
+	ch chan *Record
+
+	mu  sync.Mutex
+	err error
+}
+
+// Records - the channel records are sent to, closed when no more records are available
+func (s *Stream) Records() <-chan *Record {
+	return s.ch
+}
+
+// Err - why the stream ended early: a read error, an input the format can't read at all,
+// a line longer than the max line size, or the context completing. It is nil if the whole input was read, and is only final
+// once the channel of Records is closed.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Stats - the counters of the stream so far
+func (s *Stream) Stats() Stats {
+	return s.counters.stats()
+}
+
+func (s *Stream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+// NewStream starts reading records at the current seek offset in the file.
 // If the context completes, reading is prematurely terminated.
-func Process(ctx context.Context, f io.ReadSeeker) (<-chan *Record, error) {
+// Records that can't be decoded are logged, counted and skipped; blank lines are skipped.
+// The file is NDJSON unless another format is given with WithFormat.
+func NewStream(ctx context.Context, f io.ReadSeeker, opts ...Option) (*Stream, error) {
 
 	if f == nil {
-		return nil, fmt.Errorf("must supply a valid io.ReadSeeker (probably an *os.File) to the stream.Process function")
+		return nil, fmt.Errorf("must supply a valid io.ReadSeeker (probably an *os.File) to the stream.NewStream function")
 	}
 
 	offset, err := f.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return nil, err
 	}
-	ch := make(chan *Record)
+
+	return start(ctx, f, offset, newConfig(opts)), nil
+}
+
+// Read is NewStream for readers that can't seek, like pipes or network connections:
+// positions are counted from where r is when it is called, i.e. zero is its first byte.
+func Read(ctx context.Context, r io.Reader, opts ...Option) (*Stream, error) {
+
+	if r == nil {
+		return nil, fmt.Errorf("must supply a valid io.Reader to the stream.Read function")
+	}
+
+	return start(ctx, r, 0, newConfig(opts)), nil
+}
+
+// start - reads records from r in the background, offset being the position of its first byte
+func start(ctx context.Context, r io.Reader, offset int64, cfg config) *Stream {
+	s := &Stream{ch: make(chan *Record, cfg.bufferSize)}
+	s.counters.started = time.Now().UnixNano()
+
 	go func() {
-		defer close(ch)
-		scanner := bufio.NewScanner(f)
-		scanner.Split(func(data []byte, atEof bool) (advance int, token []byte, err error) {
-			advance, token, err = bufio.ScanLines(data, atEof)
-			if err == nil && token != nil {
-				offset += int64(advance)
+		defer close(s.ch)
+		defer func() { atomic.StoreInt64(&s.counters.ended, time.Now().UnixNano()) }()
+
+		dec, err := cfg.format.NewDecoder(countBytes(r, &s.counters.bytes))
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if d, ok := dec.(interface{ startAt(int64) }); ok {
+			d.startAt(offset)
+		}
+		// line based decoders count every line they read, blank ones included
+		countsLines := false
+		if d, ok := dec.(interface{ countLines(*int64) }); ok {
+			d.countLines(&s.counters.lines)
+			countsLines = true
+		}
+
+		var wm *watermark
+		if cfg.lateness != nil {
+			wm = newWatermark(*cfg.lateness)
+		}
+
+		for {
+			rec := &Record{}
+			err := dec.Decode(rec)
+			if err == io.EOF {
+				return
 			}
-			return advance, token, err
-		})
-		for scanner.Scan() {
-			rec := &Record{
-				Position: offset,
+
+			if err != nil {
+				var decodeErr *DecodeError
+				if !errors.As(err, &decodeErr) {
+					s.fail(err)
+					return
+				}
+				if !countsLines {
+					atomic.AddInt64(&s.counters.lines, 1)
+				}
+				atomic.AddInt64(&s.counters.decodeErrors, 1)
+				log.Println("decoding record failed", err)
+				continue
+			}
+
+			if !countsLines {
+				atomic.AddInt64(&s.counters.lines, 1)
 			}
-			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
-				log.Println("json.Unmarshal failed", err)
+			if cfg.filter != nil && !cfg.filter(rec) {
+				atomic.AddInt64(&s.counters.filtered, 1)
 				continue
 			}
+
+			if wm != nil {
+				late := wm.late(rec)
+				atomic.StoreInt64(&s.counters.watermark, wm.value())
+				if late {
+					atomic.AddInt64(&s.counters.late, 1)
+					switch cfg.latePolicy {
+					case LateDrop:
+						continue
+					case LateDivert:
+						if cfg.sideOutput != nil {
+							cfg.sideOutput(rec)
+						}
+						continue
+					}
+				}
+			}
+
 			select {
 			case _ = <-ctx.Done():
+				s.fail(ctx.Err())
 				return
-			case ch <- rec:
+			case s.ch <- rec:
+				atomic.AddInt64(&s.counters.records, 1)
 			}
 		}
 	}()
-	return ch, nil
+	return s
+}
+
+// Process returns a channel to which a stream of records are sent. Reading starts at
+// the current seek offset in the file. The channel is closed when no more records are available.
+// If the context completes, reading is prematurely terminated.
+// Process can't tell why the channel was closed, use NewStream to find out.
+func Process(ctx context.Context, f io.ReadSeeker, opts ...Option) (<-chan *Record, error) {
+	if f == nil {
+		return nil, fmt.Errorf("must supply a valid io.ReadSeeker (probably an *os.File) to the stream.Process function")
+	}
+
+	s, err := NewStream(ctx, f, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s.Records(), nil
 }
@@ -1,10 +1,17 @@
 package stream_test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/customerio/homework/stream"
 )
@@ -99,3 +106,305 @@ func match(r1, r2 *stream.Record) bool {
 
 	return true
 }
+
+func TestStreamLineTooLong(t *testing.T) {
+	var input bytes.Buffer
+	input.WriteString(`{"id":"1","type":"event","name":"a","user_id":"1"}` + "\n")
+	input.WriteString(`{"id":"2","type":"attributes","user_id":"1","data":{"big":"` + strings.Repeat("x", 1024) + `"}}` + "\n")
+	input.WriteString(`{"id":"3","type":"event","name":"a","user_id":"1"}` + "\n")
+
+	s, err := stream.NewStream(context.Background(), bytes.NewReader(input.Bytes()), stream.WithMaxLineSize(512))
+	if err != nil {
+		t.Fatalf("error processing data: %v", err)
+	}
+
+	var ids []string
+	for rec := range s.Records() {
+		ids = append(ids, rec.ID)
+	}
+
+	if len(ids) != 1 || ids[0] != "1" {
+		t.Errorf("records read before the long line: %v", ids)
+	}
+	if !errors.Is(s.Err(), bufio.ErrTooLong) {
+		t.Errorf("expected bufio.ErrTooLong, have: %v", s.Err())
+	}
+
+	// the default is large enough
+	s, err = stream.NewStream(context.Background(), bytes.NewReader(input.Bytes()))
+	if err != nil {
+		t.Fatalf("error processing data: %v", err)
+	}
+	n := 0
+	for range s.Records() {
+		n++
+	}
+	if n != 3 || s.Err() != nil {
+		t.Errorf("read %d records, err: %v", n, s.Err())
+	}
+}
+
+func TestStreamOptions(t *testing.T) {
+	input := `{"id":"1","type":"event","name":"a","user_id":"1"}
+not json
+
+{"id":"2","type":"attributes","user_id":"1","data":{"a":"b"}}
+{"id":"3","type":"event","name":"b","user_id":"2"}
+`
+
+	events := func(rec *stream.Record) bool { return rec.Type == "event" }
+	decoded := 0
+	decoder := func(line []byte, rec *stream.Record) error {
+		decoded++
+		return stream.JSONDecoder(line, rec)
+	}
+
+	s, err := stream.NewStream(context.Background(), strings.NewReader(input),
+		stream.WithFilter(events), stream.WithDecoder(decoder), stream.WithBufferSize(10))
+	if err != nil {
+		t.Fatalf("error processing data: %v", err)
+	}
+
+	var ids []string
+	for rec := range s.Records() {
+		ids = append(ids, rec.ID)
+	}
+
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "3" {
+		t.Errorf("filtered records: %v", ids)
+	}
+	if s.Err() != nil {
+		t.Errorf("unexpected error: %v", s.Err())
+	}
+
+	stats := s.Stats()
+	want := stream.Stats{Lines: 5, Bytes: int64(len(input)), Records: 2, Filtered: 1, DecodeErrors: 1}
+	stats.Elapsed = 0
+	if stats != want || decoded != 4 {
+		t.Errorf("stats don't match\nwant: %+v\nhave: %+v, %d decoded", want, stats, decoded)
+	}
+}
+
+func TestRead(t *testing.T) {
+	input := `{"id":"1","type":"event","name":"a","user_id":"1"}
+{"id":"2","type":"event","name":"b","user_id":"1"}
+`
+	// a reader that can't seek, like stdin
+	r, w := io.Pipe()
+	go func() {
+		io.WriteString(w, input)
+		w.Close()
+	}()
+
+	s, err := stream.Read(context.Background(), r)
+	if err != nil {
+		t.Fatalf("error processing data: %v", err)
+	}
+
+	var positions []int64
+	for rec := range s.Records() {
+		positions = append(positions, rec.Position)
+	}
+
+	if len(positions) != 2 || positions[0] != 51 || positions[1] != int64(len(input)) {
+		t.Errorf("positions don't start from zero: %v", positions)
+	}
+	if s.Err() != nil {
+		t.Errorf("unexpected error: %v", s.Err())
+	}
+}
+
+func TestFastDecoder(t *testing.T) {
+	lines := []string{
+		`{"data":{"city":"Deangelofurt","ip":"197.183.11.233"},"id":"229c5003","timestamp":1560073640,"type":"attributes","user_id":"11"}`,
+		` { "id" : "1", "type":"event", "name":"a\"b\\c\/dé😀\n", "user_id":"1", "timestamp":-5 } `,
+		`{"ID":"1","Type":"event","USER_ID":"2","Data":{"k":"v"},"data":{"k2":"v2"}}`,
+		`{"id":"1","extra":{"a":[1,2.5e3,true,false,null,{"b":"c"}]},"other":[],"type":"event"}`,
+		`{"id":"héllo","data":{"ключ":"значение","n":null},"name":null,"timestamp":null}`,
+		`{"id":"bad \xff utf8","name":"\ud800 lone"}`,
+		`{"data":null,"id":"1"}`,
+		`{}`,
+		`null`,
+		// type errors, the rest of the record is still decoded
+		`{"id":1,"type":"event"}`,
+		`{"id":"1","timestamp":"yesterday"}`,
+		`{"id":"1","timestamp":99999999999999999999}`,
+		`{"id":"1","timestamp":true}`,
+		`{"id":"1","data":{"a":1,"b":"2"}}`,
+		`{"id":"1","data":[]}`,
+	}
+	// the record is dropped anyway, only the errors are compared
+	invalid := []string{
+		`{"id":"1"`,
+		`{"id":"1",}`,
+		`{"id":"1"} x`,
+		`{"id":01}`,
+		`{"id":"1","x":tru}`,
+		`{"id":"a` + "\t" + `b"}`,
+		`{"id":"\x"}`,
+		`{"id":"\u12"}`,
+		`[]`,
+		`not json`,
+		``,
+	}
+
+	decode := stream.NewFastDecoder()
+	for _, line := range lines {
+		var want, have stream.Record
+		wantErr := stream.JSONDecoder([]byte(line), &want)
+		haveErr := decode([]byte(line), &have)
+
+		if (wantErr == nil) != (haveErr == nil) {
+			t.Errorf("%s\nerrors don't match, want: %v, have: %v", line, wantErr, haveErr)
+		}
+		if !reflect.DeepEqual(want, have) {
+			t.Errorf("%s\nrecords don't match\nwant: %+v\nhave: %+v", line, want, have)
+		}
+	}
+
+	for _, line := range invalid {
+		var rec stream.Record
+		if err := decode([]byte(line), &rec); err == nil {
+			t.Errorf("%s\nshould fail", line)
+		}
+	}
+}
+
+// benchmarkLines - a sample of the messages files
+func benchmarkLines(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			fmt.Fprintf(&b, `{"data":{"city":"Deangelofurt","ip":"197.183.11.%d","toyotahazle":"crucifixmatrix"},"id":"229c5003-d251-4173-b630-%012d","timestamp":1560073640,"type":"attributes","user_id":"%d"}`+"\n", i%256, i, i%1000)
+		} else {
+			fmt.Fprintf(&b, `{"data":{"opticaldarkmagenta":"HopRodRye","pabstbluetooth":"connectQuality-focused"},"id":"9b2fa8be-4ee7-4942-9ce0-%012d","name":"backuplime","timestamp":1560073640,"type":"event","user_id":"%d"}`+"\n", i, i%1000)
+		}
+	}
+	return b.Bytes()
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	lines := bytes.Split(bytes.TrimSpace(benchmarkLines(100)), []byte("\n"))
+
+	for name, decode := range map[string]stream.Decoder{
+		"json": stream.JSONDecoder,
+		"fast": stream.NewFastDecoder(),
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(lines[0])))
+			for i := 0; i < b.N; i++ {
+				var rec stream.Record
+				if err := decode(lines[i%len(lines)], &rec); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStream(b *testing.B) {
+	input := benchmarkLines(10000)
+
+	for name, opts := range map[string][]stream.Option{
+		"json": {stream.WithDecoder(stream.JSONDecoder)},
+		"fast": nil,
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				s, err := stream.NewStream(context.Background(), bytes.NewReader(input), opts...)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for range s.Records() {
+				}
+				if s.Err() != nil {
+					b.Fatal(s.Err())
+				}
+			}
+		})
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	const sec = 1560073640
+	for value, want := range map[string]int64{
+		`1560073640`:                    sec,
+		`1560073640123`:                 sec,
+		`1560073640123456`:              sec,
+		`1560073640123456789`:           sec,
+		`1560073640.9`:                  sec,
+		`1.560073640123e12`:             sec,
+		`"1560073640123"`:               sec,
+		`"2019-06-09T09:47:20Z"`:        sec,
+		`"2019-06-09T11:47:20.5+02:00"`: sec,
+		`"2019-06-09T09:47:20"`:         sec,
+		`"2019-06-09"`:                  sec - 9*3600 - 47*60 - 20,
+		`-86400`:                        -86400,
+		`10000000000000`:                1e10, // in milliseconds and microseconds the
+		`10000000000000000`:             1e10, // year 2286 is past an int64 of nanoseconds
+		`-10000000000000`:               -1e10,
+		`99999999999999999`:             99999999999,
+		`0`:                             0,
+		`null`:                          0,
+	} {
+		line := []byte(`{"id":"1","timestamp":` + value + `}`)
+		for name, decode := range map[string]stream.Decoder{"json": stream.JSONDecoder, "fast": stream.NewFastDecoder()} {
+			var rec stream.Record
+			if err := decode(line, &rec); err != nil {
+				t.Errorf("%s: %s: unexpected error: %v", name, value, err)
+			} else if rec.Timestamp != want {
+				t.Errorf("%s: %s: timestamp %d, expected %d", name, value, rec.Timestamp, want)
+			}
+		}
+	}
+
+	for _, value := range []string{"", "now", "2019-06-09 09:47", "NaN", "1e30"} {
+		if _, err := stream.ParseTimestamp(value); err == nil {
+			t.Errorf("%q should not be a timestamp", value)
+		}
+	}
+}
+
+func TestStreamLateness(t *testing.T) {
+	input := `{"id":"1","type":"event","timestamp":100}
+{"id":"2","type":"event","timestamp":200}
+{"id":"3","type":"event","timestamp":150}
+{"id":"4","type":"event","timestamp":90}
+{"id":"5","type":"event"}
+{"id":"6","type":"event","timestamp":210}
+`
+	for policy, want := range map[stream.LatePolicy][]string{
+		stream.LateAccept: {"1", "2", "3", "4", "5", "6"},
+		stream.LateDrop:   {"1", "2", "3", "5", "6"},
+		stream.LateDivert: {"1", "2", "3", "5", "6"},
+	} {
+		var diverted []string
+		side := func(rec *stream.Record) { diverted = append(diverted, rec.ID) }
+
+		s, err := stream.NewStream(context.Background(), strings.NewReader(input), stream.WithLateness(time.Minute, policy, side))
+		if err != nil {
+			t.Fatalf("error processing data: %v", err)
+		}
+
+		var ids []string
+		for rec := range s.Records() {
+			ids = append(ids, rec.ID)
+		}
+
+		if !reflect.DeepEqual(ids, want) {
+			t.Errorf("%s: records\nwant: %v\nhave: %v", policy, want, ids)
+		}
+		if policy == stream.LateDivert && !reflect.DeepEqual(diverted, []string{"4"}) {
+			t.Errorf("%s: diverted %v", policy, diverted)
+		}
+		if policy != stream.LateDivert && diverted != nil {
+			t.Errorf("%s: side output used: %v", policy, diverted)
+		}
+		if stats := s.Stats(); stats.Late != 1 || stats.Watermark != 150 {
+			t.Errorf("%s: %d late, watermark %d", policy, stats.Late, stats.Watermark)
+		}
+	}
+}
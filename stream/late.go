@@ -0,0 +1,69 @@
+package stream
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LatePolicy - what a Stream does with late records, the ones older than its watermark
+type LatePolicy int
+
+const (
+	// LateAccept - late records are sent like the others, the default
+	LateAccept LatePolicy = iota
+	// LateDrop - late records are dropped
+	LateDrop
+	// LateDivert - late records are handed to the side output instead of being sent
+	LateDivert
+)
+
+var latePolicies = []string{"accept", "drop", "divert"}
+
+func (p LatePolicy) String() string {
+	if p < 0 || int(p) >= len(latePolicies) {
+		return fmt.Sprintf("LatePolicy(%d)", int(p))
+	}
+	return latePolicies[p]
+}
+
+// ParseLatePolicy - the policy named accept, drop or divert
+func ParseLatePolicy(name string) (LatePolicy, error) {
+	for i, policy := range latePolicies {
+		if strings.EqualFold(name, policy) {
+			return LatePolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown late policy %q, must be one of %s", name, strings.Join(latePolicies, ", "))
+}
+
+// watermark - the most recent timestamp read minus the lateness allowed, in Unix seconds
+type watermark struct {
+	lateness int64
+	latest   int64
+	started  bool
+}
+
+func newWatermark(lateness time.Duration) *watermark {
+	return &watermark{lateness: int64(lateness / time.Second)}
+}
+
+// late - whether rec is older than the watermark, which then moves on with it.
+// Records without a timestamp are never late and don't move it.
+func (w *watermark) late(rec *Record) bool {
+	if rec.Timestamp == 0 {
+		return false
+	}
+	if !w.started || rec.Timestamp > w.latest {
+		w.latest, w.started = rec.Timestamp, true
+		return false
+	}
+	return rec.Timestamp < w.latest-w.lateness
+}
+
+func (w *watermark) value() int64 {
+	if !w.started {
+		return 0
+	}
+	return w.latest - w.lateness
+}
@@ -0,0 +1,86 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// NDJSON - one JSON message per line, the format of the files written by generate
+type NDJSON struct {
+	// Decoder - decodes a line, a NewFastDecoder for every stream if nil
+	Decoder Decoder
+	// MaxLineSize - lines longer than it end the stream with bufio.ErrTooLong, DefaultMaxLineSize if 0
+	MaxLineSize int
+}
+
+func (f *NDJSON) NewDecoder(r io.Reader) (RecordDecoder, error) {
+	d := &ndjsonDecoder{decoder: f.Decoder, scanner: bufio.NewScanner(r)}
+	if d.decoder == nil {
+		d.decoder = NewFastDecoder()
+	}
+
+	max := f.MaxLineSize
+	if max <= 0 {
+		max = DefaultMaxLineSize
+	}
+	// the max line size is the larger of the two, so the initial buffer can't be larger
+	initial := bufio.MaxScanTokenSize
+	if max < initial {
+		initial = max
+	}
+	d.scanner.Buffer(make([]byte, 0, initial), max)
+	d.scanner.Split(func(data []byte, atEof bool) (advance int, token []byte, err error) {
+		advance, token, err = bufio.ScanLines(data, atEof)
+		if err == nil && token != nil {
+			d.offset += int64(advance)
+		}
+		return advance, token, err
+	})
+	return d, nil
+}
+
+type ndjsonDecoder struct {
+	decoder Decoder
+	scanner *bufio.Scanner
+	offset  int64
+	// lines - the Stream's counter, incremented for every line scanned
+	lines *int64
+}
+
+// startAt - positions are offsets in a file the input starts at offset of
+func (d *ndjsonDecoder) startAt(offset int64) {
+	d.offset = offset
+}
+
+// countLines - lines are counted as they are scanned, so blank lines are too
+func (d *ndjsonDecoder) countLines(lines *int64) {
+	d.lines = lines
+}
+
+// Decode - blank lines are skipped
+func (d *ndjsonDecoder) Decode(rec *Record) error {
+	for d.scanner.Scan() {
+		if d.lines != nil {
+			atomic.AddInt64(d.lines, 1)
+		}
+
+		line := d.scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		rec.Position = d.offset
+		if err := d.decoder(line, rec); err != nil {
+			return &DecodeError{Position: d.offset, Err: err}
+		}
+		return nil
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		return fmt.Errorf("reading line after offset %d: %w", d.offset, err)
+	}
+	return io.EOF
+}
@@ -0,0 +1,97 @@
+package stream
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DefaultMaxLineSize - the longest line a Stream reads unless told otherwise, records with
+// large attribute payloads easily go past the 64KB default of bufio.Scanner
+const DefaultMaxLineSize = 16 * 1024 * 1024
+
+// Decoder - decodes a single line of the input into rec
+type Decoder func(line []byte, rec *Record) error
+
+// JSONDecoder - decodes a JSON object with encoding/json, the reference for NewFastDecoder
+func JSONDecoder(line []byte, rec *Record) error {
+	return json.Unmarshal(line, rec)
+}
+
+// Filter - records for which it returns false are not sent, e.g. to only read events
+type Filter func(rec *Record) bool
+
+type config struct {
+	maxLineSize int
+	bufferSize  int
+	decoder     Decoder
+	filter      Filter
+	format      Format
+
+	// lateness, watermark tracking is off while it is nil
+	lateness   *time.Duration
+	latePolicy LatePolicy
+	sideOutput func(*Record)
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{
+		maxLineSize: DefaultMaxLineSize,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.format == nil {
+		cfg.format = &NDJSON{Decoder: cfg.decoder, MaxLineSize: cfg.maxLineSize}
+	}
+	return cfg
+}
+
+// Option - configures a Stream
+type Option func(*config)
+
+// WithMaxLineSize - lines longer than n bytes end the stream with bufio.ErrTooLong, for NDJSON
+func WithMaxLineSize(n int) Option {
+	return func(c *config) {
+		c.maxLineSize = n
+	}
+}
+
+// WithBufferSize - the number of records buffered in the channel, by default it is unbuffered
+func WithBufferSize(n int) Option {
+	return func(c *config) {
+		c.bufferSize = n
+	}
+}
+
+// WithDecoder - decodes NDJSON lines with d instead of a NewFastDecoder
+func WithDecoder(d Decoder) Option {
+	return func(c *config) {
+		c.decoder = d
+	}
+}
+
+// WithFilter - only sends the records f returns true for, the others are counted as filtered
+func WithFilter(f Filter) Option {
+	return func(c *config) {
+		c.filter = f
+	}
+}
+
+// WithFormat - reads the input as f instead of NDJSON, WithMaxLineSize and WithDecoder are then
+// ignored in favour of the settings of f
+func WithFormat(f Format) Option {
+	return func(c *config) {
+		c.format = f
+	}
+}
+
+// WithLateness - tracks a watermark, the most recent timestamp of the records passing the filter minus lateness:
+// records older than it are late. They are counted in Stats and accepted, dropped or diverted to side depending on policy; side is called
+// from the goroutine reading the stream, before the channel is closed. Records without a timestamp are never late.
+func WithLateness(lateness time.Duration, policy LatePolicy, side func(*Record)) Option {
+	return func(c *config) {
+		c.lateness = &lateness
+		c.latePolicy = policy
+		c.sideOutput = side
+	}
+}
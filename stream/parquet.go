@@ -0,0 +1,161 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// ParquetRecord - the schema Parquet files are read with, the columns of Record with data as a
+// map of strings. All of them but data are optional, an empty data is read as nil.
+type ParquetRecord struct {
+	ID        *string           `parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Type      *string           `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Name      *string           `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	UserID    *string           `parquet:"name=user_id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Timestamp *int64            `parquet:"name=timestamp, type=INT64, repetitiontype=OPTIONAL"`
+	Data      map[string]string `parquet:"name=data, type=MAP, convertedtype=MAP, keytype=BYTE_ARRAY, keyconvertedtype=UTF8, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+}
+
+// Parquet - a Parquet file with the schema of ParquetRecord. The footer of a Parquet file is at its
+// end, so inputs that can't seek, like stdin, are read in memory first.
+type Parquet struct {
+	// BatchSize - the rows read at once, 1000 if 0
+	BatchSize int
+}
+
+func (f *Parquet) NewDecoder(r io.Reader) (RecordDecoder, error) {
+	rsa, ok := r.(readSeekerAt)
+	if !ok {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading Parquet file: %w", err)
+		}
+		rsa = bytes.NewReader(data)
+	}
+	size, err := rsa.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := reader.NewParquetReader(&parquetFile{r: rsa, size: size}, new(ParquetRecord), 1)
+	if err != nil {
+		return nil, fmt.Errorf("reading Parquet footer: %w", err)
+	}
+
+	batch := f.BatchSize
+	if batch <= 0 {
+		batch = 1000
+	}
+	return &parquetDecoder{reader: pr, rows: pr.GetNumRows(), batchSize: batch}, nil
+}
+
+type parquetDecoder struct {
+	reader    *reader.ParquetReader
+	rows      int64
+	batchSize int
+	batch     []ParquetRecord
+	n         int64
+}
+
+func (d *parquetDecoder) Decode(rec *Record) error {
+	if len(d.batch) == 0 {
+		remaining := d.rows - d.n
+		if remaining <= 0 {
+			d.reader.ReadStop()
+			return io.EOF
+		}
+		size := int64(d.batchSize)
+		if remaining < size {
+			size = remaining
+		}
+		d.batch = make([]ParquetRecord, size)
+		if err := d.reader.Read(&d.batch); err != nil {
+			return fmt.Errorf("reading Parquet rows after %d: %w", d.n, err)
+		}
+		if len(d.batch) == 0 {
+			return fmt.Errorf("reading Parquet rows after %d: %w", d.n, io.ErrUnexpectedEOF)
+		}
+	}
+
+	row := d.batch[0]
+	d.batch = d.batch[1:]
+	d.n++
+
+	rec.Position = d.n
+	rec.ID = stringOf(row.ID)
+	rec.Type = stringOf(row.Type)
+	rec.Name = stringOf(row.Name)
+	rec.UserID = stringOf(row.UserID)
+	if row.Timestamp != nil {
+		rec.Timestamp = UnixTime(*row.Timestamp).Unix()
+	}
+	if len(row.Data) > 0 {
+		rec.Data = row.Data
+	}
+	return nil
+}
+
+func stringOf(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// parquetFile - a read only source.ParquetFile, every Open is an independent cursor on the same data
+type parquetFile struct {
+	r      io.ReaderAt
+	size   int64
+	offset int64
+}
+
+func (f *parquetFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	n, err := f.r.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *parquetFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *parquetFile) Open(name string) (source.ParquetFile, error) {
+	return &parquetFile{r: f.r, size: f.size}, nil
+}
+
+func (f *parquetFile) Create(name string) (source.ParquetFile, error) {
+	return nil, errors.New("Parquet input is read only")
+}
+
+func (f *parquetFile) Write(p []byte) (int, error) {
+	return 0, errors.New("Parquet input is read only")
+}
+
+func (f *parquetFile) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package stream
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats - counters of a Stream, they are updated live while it is read
+type Stats struct {
+	// Lines - lines read, including blank ones and the ones that couldn't be decoded,
+	// or records for formats that aren't line based
+	Lines int64
+	// Bytes - bytes read from the input
+	Bytes int64
+	// Records - records sent on the channel
+	Records int64
+	// Filtered - records dropped by the filter
+	Filtered int64
+	// DecodeErrors - records the decoder failed on, they are skipped
+	DecodeErrors int64
+	// Late - records older than the watermark, whatever the late policy did with them
+	Late int64
+	// Watermark - in Unix seconds, 0 unless WithLateness is used
+	Watermark int64
+	// Elapsed - since the stream started, until it ended if it has
+	Elapsed time.Duration
+}
+
+// LinesPerSecond - read throughput in lines
+func (st Stats) LinesPerSecond() float64 {
+	if st.Elapsed <= 0 {
+		return 0
+	}
+	return float64(st.Lines) / st.Elapsed.Seconds()
+}
+
+// BytesPerSecond - read throughput in bytes
+func (st Stats) BytesPerSecond() float64 {
+	if st.Elapsed <= 0 {
+		return 0
+	}
+	return float64(st.Bytes) / st.Elapsed.Seconds()
+}
+
+// counters - the live counterpart of Stats, only accessed atomically
+type counters struct {
+	lines        int64
+	bytes        int64
+	records      int64
+	filtered     int64
+	decodeErrors int64
+	late         int64
+	watermark    int64
+	// nanoseconds since the epoch
+	started int64
+	ended   int64
+}
+
+func (c *counters) stats() Stats {
+	st := Stats{
+		Lines:        atomic.LoadInt64(&c.lines),
+		Bytes:        atomic.LoadInt64(&c.bytes),
+		Records:      atomic.LoadInt64(&c.records),
+		Filtered:     atomic.LoadInt64(&c.filtered),
+		DecodeErrors: atomic.LoadInt64(&c.decodeErrors),
+		Late:         atomic.LoadInt64(&c.late),
+		Watermark:    atomic.LoadInt64(&c.watermark),
+	}
+
+	end := atomic.LoadInt64(&c.ended)
+	if end == 0 {
+		end = time.Now().UnixNano()
+	}
+	st.Elapsed = time.Duration(end - atomic.LoadInt64(&c.started))
+	return st
+}
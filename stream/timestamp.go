@@ -0,0 +1,125 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the largest magnitude of a timestamp in each unit, they are all 1e11 seconds, in the year 5138.
+// Only nanoseconds can't get there, an int64 of them ends in the year 2262.
+const (
+	maxSeconds      = 1e11
+	maxMilliseconds = 1e14
+	maxMicroseconds = 1e17
+)
+
+// layouts of ISO 8601 timestamps besides RFC 3339, those without a zone are UTC
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// UnixTime - the time of a timestamp in seconds, milliseconds, microseconds or nanoseconds since the
+// epoch, told apart by its magnitude: producers send any of them
+func UnixTime(n int64) time.Time {
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+
+	switch {
+	case abs < maxSeconds:
+		return time.Unix(n, 0)
+	// split into seconds first, in nanoseconds they would overflow past the year 2262
+	case abs < maxMilliseconds:
+		return time.Unix(n/1e3, n%1e3*int64(time.Millisecond))
+	case abs < maxMicroseconds:
+		return time.Unix(n/1e6, n%1e6*int64(time.Microsecond))
+	}
+	return time.Unix(0, n)
+}
+
+// ParseTimestamp - a timestamp as sent by producers: a number of seconds, milliseconds, microseconds
+// or nanoseconds since the epoch (see UnixTime), with a fraction or not, or an ISO 8601 date and time
+// like 2019-06-09T09:47:20Z. Records keep the canonical Unix seconds of its time.
+func ParseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, errors.New("empty timestamp")
+	}
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return UnixTime(n), nil
+	}
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(f) || math.Abs(f) >= math.MaxInt64 {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+		}
+		// a fraction is only kept for seconds, it is dropped for the smaller units
+		if math.Abs(f) < maxSeconds {
+			sec, frac := math.Modf(f)
+			return time.Unix(int64(sec), int64(frac*1e9)), nil
+		}
+		return UnixTime(int64(f)), nil
+	}
+
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q, must be a unix timestamp or ISO 8601", s)
+}
+
+// parseTimestampJSON - the Unix seconds of a JSON timestamp, a number or a string, ok is false for null
+func parseTimestampJSON(raw []byte) (sec int64, ok bool, err error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, false, nil
+	}
+
+	s := string(raw)
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return 0, false, err
+		}
+	}
+	t, err := ParseTimestamp(s)
+	if err != nil {
+		return 0, false, err
+	}
+	return t.Unix(), true, nil
+}
+
+// UnmarshalJSON - decodes a record like encoding/json would, except for its timestamp which is normalized
+// with ParseTimestamp. Like encoding/json, fields of the wrong type don't stop the rest from being decoded.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	type record Record
+	aux := struct {
+		*record
+		Timestamp json.RawMessage `json:"timestamp"`
+	}{record: (*record)(r)}
+
+	err := json.Unmarshal(data, &aux)
+	var typeErr *json.UnmarshalTypeError
+	if err != nil && !errors.As(err, &typeErr) {
+		return err
+	}
+
+	sec, ok, terr := parseTimestampJSON(aux.Timestamp)
+	if terr != nil {
+		terr = fmt.Errorf("timestamp: %w", terr)
+	} else if ok {
+		r.Timestamp = sec
+	}
+	if err == nil {
+		err = terr
+	}
+	return err
+}
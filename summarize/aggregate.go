@@ -0,0 +1,118 @@
+package summarize
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/customerio/homework/stream"
+)
+
+// aggregation functions
+const (
+	AggregateSum   = "sum"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateAvg   = "avg"
+	AggregateCount = "count"
+)
+
+// Aggregation - a function of the numeric values of a data key of an event, like sum(purchase.price)
+type Aggregation struct {
+	Func  string
+	Event string
+	Key   string
+}
+
+// String - the name of the aggregate in Summary.Aggregates
+func (a Aggregation) String() string {
+	return a.Func + "(" + a.Event + "." + a.Key + ")"
+}
+
+// ParseAggregations - a comma separated list of aggregations, like "sum(purchase.price),max(page.duration)".
+// The data key is after the last dot, so event names can have dots. count(event.key) counts the numeric values.
+func ParseAggregations(list string) ([]Aggregation, error) {
+	var aggs []Aggregation
+	seen := make(map[Aggregation]bool)
+	add := func(a Aggregation) {
+		if !seen[a] {
+			seen[a] = true
+			aggs = append(aggs, a)
+		}
+	}
+
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		open := strings.IndexByte(spec, '(')
+		if open < 0 || !strings.HasSuffix(spec, ")") {
+			return nil, fmt.Errorf("aggregation %q must be like sum(event.key)", spec)
+		}
+		a := Aggregation{Func: strings.ToLower(spec[:open])}
+		arg := spec[open+1 : len(spec)-1]
+		dot := strings.LastIndexByte(arg, '.')
+		if dot <= 0 || dot == len(arg)-1 {
+			return nil, fmt.Errorf("aggregation %q must be of an event.key", spec)
+		}
+		a.Event, a.Key = arg[:dot], arg[dot+1:]
+
+		switch a.Func {
+		case AggregateSum, AggregateMin, AggregateMax, AggregateAvg, AggregateCount:
+		default:
+			return nil, fmt.Errorf("unknown aggregation function %q, must be sum, min, max, avg or count", a.Func)
+		}
+		add(a)
+	}
+	return aggs, nil
+}
+
+// IsAggregate - whether name is the name of an aggregate in Summary.Aggregates, like "sum(purchase.price)"
+func IsAggregate(name string) bool {
+	aggs, err := ParseAggregations(name)
+	return err == nil && len(aggs) == 1 && aggs[0].String() == name
+}
+
+// aggregate - updates the aggregates of the event with its data
+func (s *Summary) aggregate(rec *stream.Record) {
+	for _, a := range s.Aggregations {
+		if a.Event != rec.Name {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(rec.Data[a.Key]), 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			// missing or not a number
+			continue
+		}
+
+		if s.Aggregates == nil {
+			s.Aggregates = make(map[string]float64)
+		}
+		name := a.String()
+		current, prs := s.Aggregates[name]
+
+		switch a.Func {
+		case AggregateSum:
+			s.Aggregates[name] = current + value
+		case AggregateCount:
+			s.Aggregates[name] = current + 1
+		case AggregateMin:
+			if !prs || value < current {
+				s.Aggregates[name] = value
+			}
+		case AggregateMax:
+			if !prs || value > current {
+				s.Aggregates[name] = value
+			}
+		case AggregateAvg:
+			if s.Averaged == nil {
+				s.Averaged = make(map[string]int)
+			}
+			s.Averaged[name]++
+			s.Aggregates[name] = current + (value-current)/float64(s.Averaged[name])
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package summarize
+
+import (
+	"github.com/customerio/homework/stream"
+)
+
+// Cutoff - a point in time of a stream: the records up to a timestamp and/or up to a position in the stream
+type Cutoff struct {
+	// Timestamp - in Unix seconds, the records after it and those without a timestamp are left out. 0 is no limit.
+	Timestamp int64
+	// Position - the records after it are left out, see stream.Record. 0 is no limit.
+	Position int64
+}
+
+// Includes - whether the record is before the cutoff, it can be used as a stream.Filter
+func (c Cutoff) Includes(rec *stream.Record) bool {
+	if c.Timestamp != 0 && (rec.Timestamp == 0 || rec.Timestamp > c.Timestamp) {
+		return false
+	}
+	if c.Position != 0 && rec.Position > c.Position {
+		return false
+	}
+	return true
+}
+
+// AsOf - the summary of a user at the cutoff, from the records applied to its summary (see Summarizer.History).
+// Summaries don't depend on the order records are applied in, so replaying the ones before the cutoff is enough.
+func AsOf(records []*stream.Record, cutoff Cutoff, config Config) *Summary {
+	s := &Summary{Config: config}
+	for _, rec := range records {
+		if cutoff.Includes(rec) {
+			s.Apply(rec)
+		}
+	}
+	return s
+}
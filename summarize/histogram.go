@@ -0,0 +1,168 @@
+package summarize
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// histogram intervals
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+// Histogram - daily event counts. Weeks and months are summed up from days when asked for, so only days are kept.
+// The zero value is an empty histogram.
+type Histogram struct {
+	// Days - event name -> start of the day (UTC, in Unix seconds) -> count
+	Days map[string]map[int64]int
+	// Latest - the most recent day of the events added, the days kept end with it
+	Latest int64
+}
+
+// Bucket - the event counts of an interval starting at Start, in Unix seconds
+type Bucket struct {
+	Start  int64          `json:"start"`
+	Events map[string]int `json:"events"`
+}
+
+// Day - the start of the UTC day of the timestamp
+func Day(timestamp int64) int64 {
+	day := timestamp - timestamp%secondsPerDay
+	if timestamp%secondsPerDay < 0 {
+		day -= secondsPerDay
+	}
+	return day
+}
+
+// add - counts the event in place, leaving out the days before the last days of the histogram.
+// The days kept only depend on the most recent one, so the histogram doesn't depend on the order of events.
+// Days are only pruned when the most recent one moves, so adding an event is O(1) the rest of the time.
+func (h Histogram) add(name string, timestamp int64, days int) Histogram {
+	day := Day(timestamp)
+	if h.Days == nil {
+		h = Histogram{Days: make(map[string]map[int64]int), Latest: day}
+	}
+	if day > h.Latest {
+		h.Latest = day
+		h.prune(days)
+	}
+	if day < h.Latest-int64(days-1)*secondsPerDay {
+		return h
+	}
+
+	if h.Days[name] == nil {
+		h.Days[name] = make(map[int64]int)
+	}
+	h.Days[name][day]++
+	return h
+}
+
+// prune - removes the days before the last days up to Latest, in place
+func (h Histogram) prune(days int) {
+	oldest := h.Latest - int64(days-1)*secondsPerDay
+	for event, counts := range h.Days {
+		for d := range counts {
+			if d < oldest {
+				delete(counts, d)
+			}
+		}
+		if len(counts) == 0 {
+			delete(h.Days, event)
+		}
+	}
+}
+
+// Count - the number of events of the name since the start of the day of the timestamp
+func (h Histogram) Count(name string, since int64) int {
+	since = Day(since)
+	n := 0
+	for d, count := range h.Days[name] {
+		if d >= since {
+			n += count
+		}
+	}
+	return n
+}
+
+// Clone - a deep copy of the histogram
+func (h Histogram) Clone() Histogram {
+	if h.Days == nil {
+		return h
+	}
+	clone := Histogram{Days: make(map[string]map[int64]int, len(h.Days)), Latest: h.Latest}
+	for event, counts := range h.Days {
+		clone.Days[event] = make(map[int64]int, len(counts))
+		for d, n := range counts {
+			clone.Days[event][d] = n
+		}
+	}
+	return clone
+}
+
+// Buckets - the counts per day, week (starting on Monday) or month of the events, or only of the named
+// ones, in order from the first to the last bucket with events. The buckets in between are included
+// even if empty, so they can be plotted as they are.
+func (h Histogram) Buckets(interval string, names ...string) ([]Bucket, error) {
+	var start func(day int64) int64
+	var next func(start int64) int64
+	switch interval {
+	case IntervalDay:
+		start = func(day int64) int64 { return day }
+		next = func(start int64) int64 { return start + secondsPerDay }
+	case IntervalWeek:
+		start = func(day int64) int64 {
+			// days since Monday, 1970-01-01 was a Thursday
+			return day - (((day/secondsPerDay+3)%7+7)%7)*secondsPerDay
+		}
+		next = func(start int64) int64 { return start + 7*secondsPerDay }
+	case IntervalMonth:
+		start = func(day int64) int64 {
+			t := time.Unix(day, 0).UTC()
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).Unix()
+		}
+		next = func(start int64) int64 { return time.Unix(start, 0).UTC().AddDate(0, 1, 0).Unix() }
+	default:
+		return nil, fmt.Errorf("unknown interval %q, must be day, week or month", interval)
+	}
+
+	if len(names) == 0 {
+		for name := range h.Days {
+			names = append(names, name)
+		}
+	}
+
+	counts := make(map[int64]map[string]int)
+	for _, name := range names {
+		for d, n := range h.Days[name] {
+			s := start(d)
+			if counts[s] == nil {
+				counts[s] = make(map[string]int)
+			}
+			counts[s][name] += n
+		}
+	}
+	if len(counts) == 0 {
+		return []Bucket{}, nil
+	}
+
+	starts := make([]int64, 0, len(counts))
+	for s := range counts {
+		starts = append(starts, s)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var buckets []Bucket
+	for s := starts[0]; s <= starts[len(starts)-1]; s = next(s) {
+		events := counts[s]
+		if events == nil {
+			events = make(map[string]int)
+		}
+		buckets = append(buckets, Bucket{Start: s, Events: events})
+	}
+	return buckets, nil
+}
@@ -0,0 +1,202 @@
+// Package summarize holds the rules turning a stream of messages into the attributes
+// and event counts of users. They are the same whether messages come from a file or
+// are tracked live, so they are only defined here.
+package summarize
+
+import (
+	"github.com/customerio/homework/stream"
+)
+
+// record types
+const (
+	TypeEvent      = "event"
+	TypeAttributes = "attributes"
+)
+
+// Version - when an attribute was set, the timestamp then the id of the record setting it. Records with the
+// same timestamp are ordered by id, so the merge doesn't depend on the order records arrive in.
+type Version struct {
+	Timestamp int64
+	ID        string
+}
+
+// Before - whether v is older than o
+func (v Version) Before(o Version) bool {
+	if v.Timestamp != o.Timestamp {
+		return v.Timestamp < o.Timestamp
+	}
+	return v.ID < o.ID
+}
+
+// Summary - what is known of a single user
+type Summary struct {
+	// Attributes - the merged attributes, nil until the first attributes record
+	Attributes map[string]string
+	// Versions - attribute name -> version of its value. Attributes without one, like those of a customer
+	// loaded from the datastore, have the version of Timestamp with an empty id.
+	Versions map[string]Version
+	// Timestamp - of the most recent attributes record merged
+	Timestamp int64
+	// Events - event name -> count
+	Events map[string]int
+	// Aggregates - aggregation name -> value, see Aggregation. Only for events with a numeric value.
+	Aggregates map[string]float64
+	// Averaged - avg aggregate name -> the number of values averaged so far, needed to keep it up to date
+	// but not an aggregate itself. An avg without it starts over with the next value.
+	Averaged map[string]int
+	// Histogram - daily event counts of the last HistogramDays, empty unless configured
+	Histogram Histogram
+	// Config - shared with the Summarizer so read only
+	Config
+}
+
+// Config - what is computed of events besides their counts
+type Config struct {
+	// Aggregations - computed as events are applied
+	Aggregations []Aggregation
+	// HistogramDays - the days of the Histogram kept, up to the most recent event of the user, 0 for none
+	HistogramDays int
+}
+
+// Identified - whether an attributes record of the user has been seen
+func (s *Summary) Identified() bool {
+	return s.Attributes != nil
+}
+
+// version - of the value of the attribute
+func (s *Summary) version(name string) Version {
+	if v, ok := s.Versions[name]; ok {
+		return v
+	}
+	return Version{Timestamp: s.Timestamp}
+}
+
+// Apply - merges an attributes record or counts an event, in place.
+// Attributes are merged to prevent a last-write-wins scenario: every attribute keeps the value of the
+// most recent record setting it, by Version, so the summary is the same whatever order the records
+// come in. A value equal in both timestamp and id, i.e. a record sent twice, is settled by the value.
+// Events are counted as given, dropping duplicates is up to the caller (see Summarizer).
+// It returns false for records of an unknown type, which are ignored.
+func (s *Summary) Apply(rec *stream.Record) bool {
+	switch rec.Type {
+	case TypeEvent:
+		if s.Events == nil {
+			s.Events = make(map[string]int)
+		}
+		s.Events[rec.Name]++
+		s.aggregate(rec)
+		if s.HistogramDays > 0 && rec.Timestamp != 0 {
+			s.Histogram = s.Histogram.add(rec.Name, rec.Timestamp, s.HistogramDays)
+		}
+
+	case TypeAttributes:
+		if s.Attributes == nil {
+			s.Attributes = make(map[string]string, len(rec.Data))
+		}
+		if s.Versions == nil {
+			s.Versions = make(map[string]Version, len(rec.Data))
+		}
+
+		v := Version{Timestamp: rec.Timestamp, ID: rec.ID}
+		for name, value := range rec.Data {
+			current, prs := s.Attributes[name]
+			if prs {
+				cv := s.version(name)
+				if v.Before(cv) || v == cv && value <= current {
+					continue
+				}
+			}
+			s.Attributes[name] = value
+			s.Versions[name] = v
+		}
+
+		// the versions of attributes without one are kept as the timestamp moves on
+		if rec.Timestamp > s.Timestamp {
+			for name := range s.Attributes {
+				if _, ok := s.Versions[name]; !ok {
+					s.Versions[name] = Version{Timestamp: s.Timestamp}
+				}
+			}
+			s.Timestamp = rec.Timestamp
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+// EventSet - the ids of the events counted so far
+type EventSet interface {
+	// Add - adds the id, returning false if it was already there
+	Add(id string) (bool, error)
+}
+
+// EventIDs - an EventSet in memory
+type EventIDs map[string]bool
+
+func (ids EventIDs) Add(id string) (bool, error) {
+	if ids[id] {
+		return false, nil
+	}
+	ids[id] = true
+	return true, nil
+}
+
+// Dedup - events are counted once per id: whether the record is an event counted already,
+// otherwise its id is added to the set. Other records are never duplicates.
+func Dedup(rec *stream.Record, counted EventSet) (bool, error) {
+	if rec.Type != TypeEvent {
+		return false, nil
+	}
+	added, err := counted.Add(rec.ID)
+	return !added, err
+}
+
+// Summarizer - summaries of all the users of a stream, every event is counted once per id
+type Summarizer struct {
+	// Users - user_id -> summary
+	Users map[string]*Summary
+	// EventIDs - ids of the events counted
+	EventIDs EventIDs
+	// History - user_id -> the records applied to the summary, in order. Only kept after KeepHistory.
+	History map[string][]*stream.Record
+	// Config - of the summary of every user, set before applying records
+	Config
+}
+
+func New() *Summarizer {
+	return &Summarizer{
+		Users:    make(map[string]*Summary),
+		EventIDs: make(EventIDs),
+	}
+}
+
+// KeepHistory - keeps the records applied from now on in History, for AsOf
+func (z *Summarizer) KeepHistory() {
+	if z.History == nil {
+		z.History = make(map[string][]*stream.Record)
+	}
+}
+
+// Apply - applies the record to the summary of its user.
+// It returns false if the record was dropped, i.e. a duplicate event or a record of an unknown type.
+func (z *Summarizer) Apply(rec *stream.Record) bool {
+	if duplicate, _ := Dedup(rec, z.EventIDs); duplicate {
+		return false
+	}
+
+	s, prs := z.Users[rec.UserID]
+	if !prs {
+		s = &Summary{Config: z.Config}
+	}
+	if !s.Apply(rec) {
+		return false
+	}
+
+	z.Users[rec.UserID] = s
+	if z.History != nil {
+		z.History[rec.UserID] = append(z.History[rec.UserID], rec)
+	}
+	return true
+}
@@ -0,0 +1,307 @@
+package summarize_test
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/customerio/homework/stream"
+	"github.com/customerio/homework/summarize"
+)
+
+func TestSummarizer(t *testing.T) {
+	var records = []*stream.Record{
+		{ID: "a1", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "bill@example.com", "city": "toronto"}, Timestamp: 20},
+		{ID: "e1", Type: summarize.TypeEvent, Name: "signup", UserID: "1", Timestamp: 20},
+		{ID: "e1", Type: summarize.TypeEvent, Name: "signup", UserID: "1", Timestamp: 20},
+		{ID: "e2", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Timestamp: 25},
+		// older, only sets the keys it is the most recent for
+		{ID: "a2", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"city": "oslo", "zip": "0150"}, Timestamp: 10},
+		// more recent, overwrites common keys
+		{ID: "a3", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "william@example.com"}, Timestamp: 30},
+		{ID: "e3", Type: summarize.TypeEvent, Name: "purchase", UserID: "2", Timestamp: 25},
+		{ID: "x1", Type: "unknown", UserID: "3"},
+	}
+
+	var expected = map[string]*summarize.Summary{
+		"1": {
+			Attributes: map[string]string{"email": "william@example.com", "city": "toronto", "zip": "0150"},
+			Versions: map[string]summarize.Version{
+				"email": {Timestamp: 30, ID: "a3"},
+				"city":  {Timestamp: 20, ID: "a1"},
+				"zip":   {Timestamp: 10, ID: "a2"},
+			},
+			Timestamp: 30,
+			Events:    map[string]int{"signup": 1, "purchase": 1},
+		},
+		"2": {
+			Events: map[string]int{"purchase": 1},
+		},
+	}
+
+	sz := summarize.New()
+
+	var applied int
+	for _, rec := range records {
+		if sz.Apply(rec) {
+			applied++
+		}
+	}
+
+	if applied != 6 {
+		t.Errorf("applied %d records, want 6", applied)
+	}
+	if !reflect.DeepEqual(sz.Users, expected) {
+		t.Errorf("summaries don't match\nwant: %#v\nhave: %#v", expected, sz.Users)
+	}
+	if sz.Users["2"].Identified() {
+		t.Errorf("user 2 has no attributes but is identified")
+	}
+}
+
+func TestSummarizerOrder(t *testing.T) {
+	var records = []*stream.Record{
+		{ID: "a1", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "a@example.com", "city": "toronto"}, Timestamp: 10},
+		{ID: "a2", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"email": "b@example.com"}, Timestamp: 30},
+		// older than a2 but newer than a1 for the city
+		{ID: "a3", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"city": "oslo", "email": "c@example.com"}, Timestamp: 20},
+		// same timestamp, a5 wins by id
+		{ID: "a5", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"plan": "pro"}, Timestamp: 40},
+		{ID: "a4", Type: summarize.TypeAttributes, UserID: "1", Data: map[string]string{"plan": "free"}, Timestamp: 40},
+		// sent twice with different values, the largest value wins
+		{ID: "a6", Type: summarize.TypeAttributes, UserID: "2", Data: map[string]string{"zip": "0150"}, Timestamp: 5},
+		{ID: "a6", Type: summarize.TypeAttributes, UserID: "2", Data: map[string]string{"zip": "0151"}, Timestamp: 5},
+		{ID: "e1", Type: summarize.TypeEvent, Name: "signup", UserID: "1", Timestamp: 10},
+		{ID: "e1", Type: summarize.TypeEvent, Name: "signup", UserID: "1", Timestamp: 10},
+		{ID: "e2", Type: summarize.TypeEvent, Name: "purchase", UserID: "2", Timestamp: 1},
+	}
+
+	want := map[string]map[string]string{
+		"1": {"email": "b@example.com", "city": "oslo", "plan": "pro"},
+		"2": {"zip": "0151"},
+	}
+
+	apply := func(order []int) *summarize.Summarizer {
+		sz := summarize.New()
+		for _, i := range order {
+			sz.Apply(records[i])
+		}
+		return sz
+	}
+
+	order := make([]int, len(records))
+	for i := range order {
+		order[i] = i
+	}
+	reference := apply(order)
+	for id, attributes := range want {
+		if !reflect.DeepEqual(reference.Users[id].Attributes, attributes) {
+			t.Errorf("user %s: attributes don't match\nwant: %v\nhave: %v", id, attributes, reference.Users[id].Attributes)
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 1000; n++ {
+		r.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		if sz := apply(order); !reflect.DeepEqual(sz, reference) {
+			t.Fatalf("summary depends on the order %v\nwant: %#v\nhave: %#v", order, reference.Users["1"], sz.Users["1"])
+		}
+	}
+}
+
+func TestCutoff(t *testing.T) {
+	lines := []string{
+		`{"id":"a1","type":"attributes","user_id":"1","data":{"plan":"free"},"timestamp":100}`,
+		`{"id":"e1","type":"event","name":"login","user_id":"1","timestamp":300}`,
+		`{"id":"a2","type":"attributes","user_id":"1","data":{"plan":"pro"},"timestamp":200}`,
+		`{"id":"e2","type":"event","name":"login","user_id":"1"}`,
+	}
+	input := strings.Join(lines, "\n") + "\n"
+	// NDJSON positions are the offset right after the record, i.e. the -as-of-position of the file
+	second := int64(len(lines[0]) + len(lines[1]) + 2)
+
+	var tests = []struct {
+		name   string
+		cutoff summarize.Cutoff
+		plan   string
+		logins int
+	}{
+		{"none", summarize.Cutoff{}, "pro", 2},
+		{"position", summarize.Cutoff{Position: second}, "free", 1},
+		{"position within a record", summarize.Cutoff{Position: second - 1}, "free", 0},
+		// records without a timestamp are after any of them
+		{"timestamp", summarize.Cutoff{Timestamp: 250}, "pro", 0},
+		{"both", summarize.Cutoff{Timestamp: 250, Position: second}, "free", 0},
+	}
+
+	for _, tt := range tests {
+		st, err := stream.Read(context.Background(), strings.NewReader(input), stream.WithFilter(tt.cutoff.Includes))
+		if err != nil {
+			t.Fatalf("%s: error reading: %v", tt.name, err)
+		}
+
+		sz := summarize.New()
+		sz.KeepHistory()
+		for rec := range st.Records() {
+			sz.Apply(rec)
+		}
+		if user := sz.Users["1"]; user.Attributes["plan"] != tt.plan || user.Events["login"] != tt.logins {
+			t.Errorf("%s: summary %+v, want the %s plan and %d logins", tt.name, user, tt.plan, tt.logins)
+		}
+
+		// replaying the whole history gives the same summary as filtering the stream
+		all := summarize.New()
+		all.KeepHistory()
+		st, _ = stream.Read(context.Background(), strings.NewReader(input))
+		for rec := range st.Records() {
+			all.Apply(rec)
+		}
+		if asOf := summarize.AsOf(all.History["1"], tt.cutoff, summarize.Config{}); !reflect.DeepEqual(asOf, sz.Users["1"]) {
+			t.Errorf("%s: as of the cutoff\nwant: %#v\nhave: %#v", tt.name, sz.Users["1"], asOf)
+		}
+	}
+}
+
+func TestAggregations(t *testing.T) {
+	if _, err := summarize.ParseAggregations("median(purchase.price)"); err == nil {
+		t.Errorf("unknown function accepted")
+	}
+	if _, err := summarize.ParseAggregations("sum(price)"); err == nil {
+		t.Errorf("aggregation without an event accepted")
+	}
+
+	aggs, err := summarize.ParseAggregations("sum(purchase.price), min(purchase.price),max(page.view.duration),avg(purchase.price)")
+	if err != nil {
+		t.Fatalf("error parsing aggregations: %v", err)
+	}
+	if len(aggs) != 4 || aggs[3].String() != "avg(purchase.price)" {
+		t.Errorf("aggregations don't match: %v", aggs)
+	}
+	if aggs[2].Event != "page.view" || aggs[2].Key != "duration" {
+		t.Errorf("key is after the last dot: %#v", aggs[2])
+	}
+	for name, want := range map[string]bool{"avg(purchase.price)": true, "sum(page.view.duration)": true, "AVG(purchase.price)": false, "plan": false, "avg(price)": false} {
+		if summarize.IsAggregate(name) != want {
+			t.Errorf("IsAggregate(%q) != %v", name, want)
+		}
+	}
+
+	sz := summarize.New()
+	sz.Aggregations = aggs
+	for _, rec := range []*stream.Record{
+		{ID: "e1", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Data: map[string]string{"price": "10.5"}},
+		{ID: "e2", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Data: map[string]string{"price": "2"}},
+		{ID: "e2", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Data: map[string]string{"price": "2"}},
+		// counted, but not aggregated
+		{ID: "e3", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Data: map[string]string{"price": "free"}},
+		{ID: "e4", Type: summarize.TypeEvent, Name: "purchase", UserID: "1"},
+		{ID: "e5", Type: summarize.TypeEvent, Name: "page.view", UserID: "1", Data: map[string]string{"duration": "-3"}},
+		{ID: "e6", Type: summarize.TypeEvent, Name: "signup", UserID: "2", Data: map[string]string{"price": "1"}},
+	} {
+		sz.Apply(rec)
+	}
+
+	want := map[string]float64{
+		"sum(purchase.price)":     12.5,
+		"min(purchase.price)":     2,
+		"avg(purchase.price)":     6.25,
+		"max(page.view.duration)": -3,
+	}
+	if have := sz.Users["1"].Aggregates; !reflect.DeepEqual(have, want) {
+		t.Errorf("aggregates don't match\nwant: %v\nhave: %v", want, have)
+	}
+	if sz.Users["1"].Averaged["avg(purchase.price)"] != 2 {
+		t.Errorf("the values averaged aren't counted: %v", sz.Users["1"].Averaged)
+	}
+	if sz.Users["1"].Events["purchase"] != 4 || sz.Users["2"].Aggregates != nil {
+		t.Errorf("unexpected summaries: %#v %#v", sz.Users["1"], sz.Users["2"])
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	const day = 24 * 60 * 60
+	// Thursday 2019-06-06, Monday 2019-06-10 and Wednesday 2019-07-03, at noon
+	var (
+		thu = int64(1559822400)
+		mon = thu + 4*day
+		jul = thu + 27*day
+	)
+	records := []*stream.Record{
+		{ID: "e1", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Timestamp: thu},
+		{ID: "e2", Type: summarize.TypeEvent, Name: "purchase", UserID: "1", Timestamp: thu + 3600},
+		{ID: "e3", Type: summarize.TypeEvent, Name: "view", UserID: "1", Timestamp: mon},
+		{ID: "e4", Type: summarize.TypeEvent, Name: "view", UserID: "1", Timestamp: jul},
+		// older than the 30 days kept
+		{ID: "e5", Type: summarize.TypeEvent, Name: "view", UserID: "1", Timestamp: thu - 5*day},
+		// without a timestamp, only counted
+		{ID: "e6", Type: summarize.TypeEvent, Name: "view", UserID: "1"},
+	}
+
+	for i := 0; i < 20; i++ {
+		sz := summarize.New()
+		sz.HistogramDays = 30
+		for _, j := range rand.Perm(len(records)) {
+			sz.Apply(records[j])
+		}
+
+		want := summarize.Histogram{
+			Days: map[string]map[int64]int{
+				"purchase": {summarize.Day(thu): 2},
+				"view":     {summarize.Day(mon): 1, summarize.Day(jul): 1},
+			},
+			Latest: summarize.Day(jul),
+		}
+		if have := sz.Users["1"].Histogram; !reflect.DeepEqual(have, want) {
+			t.Fatalf("histogram doesn't match\nwant: %v\nhave: %v", want, have)
+		}
+		if sz.Users["1"].Events["view"] != 4 {
+			t.Fatalf("events not counted: %v", sz.Users["1"].Events)
+		}
+
+		// the days kept move with the most recent event
+		sz.Apply(&stream.Record{ID: "e7", Type: summarize.TypeEvent, Name: "view", UserID: "1", Timestamp: mon + 30*day})
+		want = summarize.Histogram{
+			Days:   map[string]map[int64]int{"view": {summarize.Day(jul): 1, summarize.Day(mon + 30*day): 1}},
+			Latest: summarize.Day(mon + 30*day),
+		}
+		if have := sz.Users["1"].Histogram; !reflect.DeepEqual(have, want) {
+			t.Fatalf("histogram after a later event doesn't match\nwant: %v\nhave: %v", want, have)
+		}
+	}
+
+	h := summarize.Histogram{Days: map[string]map[int64]int{
+		"purchase": {summarize.Day(thu): 2},
+		"view":     {summarize.Day(mon): 1, summarize.Day(jul): 1},
+	}}
+	if n := h.Count("view", mon); n != 2 {
+		t.Errorf("views since monday: %d, want 2", n)
+	}
+
+	days, err := h.Buckets(summarize.IntervalDay, "purchase")
+	if err != nil || len(days) != 1 || days[0].Start != summarize.Day(thu) || days[0].Events["purchase"] != 2 {
+		t.Errorf("daily purchases don't match: %v, %v", days, err)
+	}
+	if days, _ := h.Buckets(summarize.IntervalDay); len(days) != 28 || len(days[1].Events) != 0 {
+		t.Errorf("days between the first and last must be there, empty: %v", days)
+	}
+
+	weeks, _ := h.Buckets(summarize.IntervalWeek)
+	// mondays 2019-06-03 to 2019-07-01
+	if len(weeks) != 5 || weeks[0].Start != 1559520000 || weeks[1].Events["view"] != 1 || weeks[4].Start != 1561939200 {
+		t.Errorf("weeks don't match: %v", weeks)
+	}
+	months, _ := h.Buckets(summarize.IntervalMonth)
+	want := []summarize.Bucket{
+		{Start: 1559347200, Events: map[string]int{"purchase": 2, "view": 1}},
+		{Start: 1561939200, Events: map[string]int{"view": 1}},
+	}
+	if !reflect.DeepEqual(months, want) {
+		t.Errorf("months don't match\nwant: %v\nhave: %v", want, months)
+	}
+
+	if _, err := h.Buckets("year"); err == nil {
+		t.Errorf("unknown interval accepted")
+	}
+}
@@ -23,3 +23,14 @@ func MergeMaps(a, b map[string]string, keepA bool) map[string]string {
 
 	return c
 }
+
+// CopyMap - returns a copy of a map of string(key and value)
+func CopyMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
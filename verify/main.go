@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -81,38 +80,27 @@ func main() {
 
 	var serverCustomers = make(map[int]*serve.Customer)
 
-	for i := 0; i < len(verifyCustomers); i++ { // should take no more than len(verifyCustomers) iterations, if pagination were set to 1 per page
-
-		resp, err := http.Get(fmt.Sprintf("%s/customers?page=%d&per_page=10000", *serverAddr, i+1))
-		if err != nil {
-			log.Fatalf("error requesting customers: %s", err)
-		}
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatalf("error reading response body: %s", err)
-		}
+	// the export streams every customer in one response, one JSON customer per line
+	resp, err := http.Get(fmt.Sprintf("%s/customers/export?format=ndjson", *serverAddr))
+	if err != nil {
+		log.Fatalf("error requesting customers: %s", err)
+	}
+	defer resp.Body.Close()
 
-		var reply struct {
-			Customers []*serve.Customer `json:"customers"`
-			Meta      struct {
-				Page    int `json:"page"`
-				PerPage int `json:"per_page"`
-				Total   int `json:"total"`
-			} `json:"meta"`
-		}
-		if err := json.Unmarshal(body, &reply); err != nil {
-			log.Fatalf("error unmarshalling response body: %s", err)
-		}
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("error requesting customers: %s", resp.Status)
+	}
 
-		for _, cust := range reply.Customers {
-			cust.LastUpdated = 0 // not comparing these in our summaries
-			serverCustomers[cust.ID] = cust
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var cust serve.Customer
+		if err := dec.Decode(&cust); err != nil {
+			log.Fatalf("error decoding customer: %s", err)
 		}
 
-		if len(reply.Customers) == 0 {
-			break
-		}
+		cust.LastUpdated = 0 // not comparing these in our summaries
+		cust.Version = 0
+		serverCustomers[cust.ID] = &cust
 	}
 
 	for id, vrfy := range verifyCustomers {